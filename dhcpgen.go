@@ -8,6 +8,8 @@ import (
 	"net"
 	"os"
 	"regexp"
+	"runtime"
+	"runtime/pprof"
 	"strconv"
 	"strings"
 )
@@ -21,9 +23,9 @@ func uint32ToIP(ip uint32) net.IP {
 	return net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
 }
 
-func isValidDNSDomain(domain string) bool {
-	var dnsRegex = regexp.MustCompile(`^(?i:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)(\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)*(\.)?$`)
+var dnsRegex = regexp.MustCompile(`^(?i:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)(\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)*(\.)?$`)
 
+func isValidDNSDomain(domain string) bool {
 	if len(domain) > 253 {
 		return false
 	}
@@ -163,6 +165,49 @@ func generateGenerateStatements(startIP, endIP string, hostStart int, hostName s
 	return statements, nil
 }
 
+// Start CPU profiling if requested.  The returned function stops it and
+// writes the heap profile, if one was requested.
+func startProfiling(cpuFile, memFile string) func() {
+	if cpuFile != "" {
+		f, err := os.Create(cpuFile)
+		if err != nil {
+			fmt.Printf("Error creating CPU profile: %v\n", err)
+			os.Exit(1)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Printf("Error starting CPU profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	return func() {
+		if cpuFile != "" {
+			pprof.StopCPUProfile()
+		}
+		if memFile != "" {
+			f, err := os.Create(memFile)
+			if err != nil {
+				fmt.Printf("Error creating memory profile: %v\n", err)
+				os.Exit(1)
+			}
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Printf("Error writing memory profile: %v\n", err)
+			}
+			f.Close()
+		}
+	}
+}
+
+var stopProfiling = func() {} // Stops -cpuprofile and -memprofile profiling
+
+// Exit with the given status, stopping profiling first so that the
+// profiles are written.
+func exit(code int) {
+	stopProfiling()
+	os.Exit(code)
+}
+
 func main() {
 	hostStart := flag.Int("hoststart", 0, "Where to start host numbering (optional)")
 	hostName := flag.String("hostname", "dhcp", "Hostname prefix (optional)")
@@ -172,6 +217,8 @@ func main() {
 	mx := flag.String("mx", "", "Add MX record (optional)")
 	mx_pri := flag.Uint("mx_priority", 0, "MX priority (optional, default 0)")
 	help := flag.Bool("h", false, "Show help")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile to this file (debugging)")
+	memProfile := flag.String("memprofile", "", "Write a memory profile to this file (debugging)")
 
 	flag.Parse()
 
@@ -209,6 +256,9 @@ func main() {
 		os.Exit(1)
 	}
 
+	stopProfiling = startProfiling(*cpuProfile, *memProfile)
+	defer stopProfiling()
+
 	statements, err := generateGenerateStatements(startIP, endIP, *hostStart, *hostName, *origin, *comments, *mx, *mx_pri)
 	if err != nil {
 		fmt.Println("Error:", err)
@@ -222,7 +272,7 @@ func main() {
 		outFile, err = os.Create(*outputFile)
 		if err != nil {
 			fmt.Printf("Error creating output file: %v\n", err)
-			os.Exit(1)
+			exit(1)
 		}
 		defer outFile.Close()
 	}
@@ -0,0 +1,37 @@
+// Run with the tool's source and the golden-file harness; see README.md:
+//
+//	go test dhcpgen.go dhcpgen_test.go golden_test.go
+
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGolden(t *testing.T) {
+	runGolden(t, "dhcpgen", []golden_t{
+		{"basic", []string{"192.0.2.10", "192.0.2.20"}, false},
+		{"hoststart", []string{"-hoststart", "100", "10.0.0.1", "10.0.0.5"}, false},
+		{"multi-net", []string{"-origin", "example.com", "-hostname", "pool", "-comments", "-mx", "mail.example.com.", "-mx_priority", "10", "10.1.0.200", "10.1.2.50"}, false},
+		{"bad-origin", []string{"-origin", "bad..example.com", "10.0.0.1", "10.0.0.2"}, true},
+	}, nil)
+}
+
+var domains = []string{"example.com", "dhcp.corp.example.com.", "bad..example.com", "a-very-long-label-for-a-benchmark.example.org"}
+
+// isValidDNSDomain with the package-level regexp, and as it was when the
+// regexp was compiled on every call
+func BenchmarkIsValidDNSDomain(b *testing.B) {
+	b.Run("package", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			isValidDNSDomain(domains[i%len(domains)])
+		}
+	})
+	b.Run("per-call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			re := regexp.MustCompile(dnsRegex.String())
+			re.MatchString(domains[i%len(domains)])
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Golden-file tests, shared by the tools.  Each tool's tests run it over
+// the files in testdata/<tool> and compare what it prints, byte for byte,
+// with testdata/<tool>/<case>.golden.  After an intended change to the
+// output, rewrite the golden files with -update and review the diff:
+//
+//	go test mkarpa.go mkarpa_test.go golden_test.go -update
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+// Set in the environment of the test binary when it is to run the tool
+// itself rather than the tests.
+const goldenEnv = "ZONE_TOOLS_GOLDEN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(goldenEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// A golden test case: the tool's arguments, the name of the golden file,
+// and whether the tool should fail.
+type golden_t struct {
+	name string
+	args []string
+	fail bool
+}
+
+// Times in time.UnixDate format, as written in the tools' headers
+var unixDate = regexp.MustCompile(`[A-Z][a-z]{2} [A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d \S+ \d{4}`)
+
+// Run the tool in testdata/<tool> for each case and compare what it
+// prints on standard output and error with the case's golden file.  Times
+// and the local host and path are masked first, then anything else that
+// mask hides.
+func runGolden(t *testing.T, tool string, cases []golden_t, mask func(string) string) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := filepath.Abs(filepath.Join("testdata", tool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			cmd := exec.Command(exe, c.args...)
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(), goldenEnv+"=1")
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			err := cmd.Run()
+			var exitErr *exec.ExitError
+			if err != nil && !errors.As(err, &exitErr) {
+				t.Fatal(err)
+			}
+			if (err != nil) != c.fail {
+				t.Errorf("%s %s: exit status %v, want failure %v\n%s", tool, strings.Join(c.args, " "), err, c.fail, out.String())
+			}
+
+			got := unixDate.ReplaceAllString(out.String(), "<date>")
+			got = strings.ReplaceAll(got, host+":"+dir, "<host>:testdata/"+tool)
+			if mask != nil {
+				got = mask(got)
+			}
+
+			golden := filepath.Join(dir, c.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("%s %s: output differs from %s; rerun with -update and review the diff\ngot:\n%s", tool, strings.Join(c.args, " "), golden, got)
+			}
+		})
+	}
+}
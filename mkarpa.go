@@ -4,24 +4,31 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"container/list"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"runtime/pprof"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
 )
 
 type soa_t struct {
 	authns  string
 	domain  string
 	contact string
-	serial  uint64
+	serial  uint32
 	refresh uint64
 	retry   uint64
 	expire  uint64
@@ -29,22 +36,180 @@ type soa_t struct {
 	ns      []string
 }
 
+// A PTR record generated from a forward A or AAAA record
+type ptr_t struct {
+	addr   net.IP
+	host   string
+	origin string // The reverse zone the record belongs in
+	ttl    string // The A record's TTL in seconds, if known
+}
+
+// A $GENERATE directive for PTR records, converted from one for A or
+// AAAA records
+type generate_t struct {
+	start  int
+	stop   int
+	step   int
+	owner  string // Fully-qualified template for the PTR owner
+	host   string // Template for the PTR target
+	addr   string // Template for the forward address
+	ttl    string // The A records' TTL in seconds, if known
+	origin string // The reverse zone the directive belongs in
+}
+
+// Repeatable -cidr flag: reverse zones for /8, /16 and /24 networks, and
+// RFC 2317 classless delegations for longer prefixes
+type cidrList []*net.IPNet
+
+func (c *cidrList) String() string {
+	var nets []string
+	for _, n := range *c {
+		nets = append(nets, n.String())
+	}
+	return strings.Join(nets, ",")
+}
+
+func (c *cidrList) Set(v string) error {
+	_, n, err := net.ParseCIDR(v)
+	if err != nil {
+		return err
+	}
+	ones, _ := n.Mask.Size()
+	if n.IP.To4() == nil || ones > 31 || (ones <= 24 && ones%8 != 0) || ones == 0 {
+		return fmt.Errorf("%s is not an IPv4 /8, /16, /24 or /25 to /31 network", v)
+	}
+	n.IP = n.IP.To4()
+	for _, o := range *c {
+		if o.Contains(n.IP) || n.Contains(o.IP) {
+			return fmt.Errorf("%s overlaps %s", v, o)
+		}
+	}
+	*c = append(*c, n)
+	return nil
+}
+
+// Repeatable -exclude-net flag
+type netList []*net.IPNet
+
+func (l *netList) String() string {
+	var nets []string
+	for _, n := range *l {
+		nets = append(nets, n.String())
+	}
+	return strings.Join(nets, ",")
+}
+
+func (l netList) contains(addr net.IP) bool {
+	for _, n := range l {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *netList) Set(v string) error {
+	_, n, err := net.ParseCIDR(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, n)
+	return nil
+}
+
+// Repeatable -exclude-host and -only-origin flags: a glob, or a regular
+// expression between slashes.  Both are matched case-insensitively
+// against the name without its trailing dot.
+type patternList []*regexp.Regexp
+
+func (l patternList) matches(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	for _, re := range l {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *patternList) String() string {
+	var res []string
+	for _, re := range *l {
+		res = append(res, re.String())
+	}
+	return strings.Join(res, ",")
+}
+
+func (l *patternList) Set(v string) error {
+	expr := v
+	if len(v) > 1 && strings.HasPrefix(v, "/") && strings.HasSuffix(v, "/") {
+		expr = v[1 : len(v)-1]
+	} else {
+		expr = regexp.QuoteMeta(v)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		expr = "^" + expr + "$"
+	}
+	re, err := regexp.Compile("(?i)" + expr)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, re)
+	return nil
+}
+
+// An entry in the generated reverse zone: a PTR record or a $GENERATE
+// directive
+type rr_t interface {
+	String() string
+	zone() string
+}
+
+// A PTR record read from, or expanded for, a reverse zone, keyed by its
+// fully-qualified owner name.
+type ptrName_t struct {
+	name string
+	host string
+}
+
 var domain string
+var directiveOrigin string // Reverse origin from -d or ;$reverse-domain
+var v6Boundary int
+var v4Boundary int
+var v4BoundarySet bool // -v4-boundary overrides ;$reverse-domain
+var cidrs cidrList
 var ttl string
+var currentTTL string // Value of the last $TTL, in seconds
+var outputTTL string  // Value of the generated zone's $TTL, in seconds
+var uniformTTL bool   // -ptr-ttl: no per-record TTLs
+var fullyQualified bool
+var backup bool
 var soa soa_t
 var NS_A_RR string
 
 var zone *list.List
 
+var keepGoing bool
+var maxIncludeDepth int
+var includeChain []string // Absolute paths of the files being parsed
+var ttlUnits bool
+var serialMode string
+var parseErrors []*ParseError
+var excludeNets netList
+var excludeHosts patternList
+var onlyNets netList
+var onlyOrigins patternList
+var family string             // -family: "4", "6" or "both"
+var dualStack string          // -dual-stack: "off", "warn" or "require"
+var families map[string]int   // Host -> address families seen, for -dual-stack
+var familyOrder []string      // Hosts in the order first seen
+var stopProfiling = func() {} // Stops -cpuprofile and -memprofile profiling
+
 // Regular expressions
-var IN_A = regexp.MustCompile(`IN[\s|\t]+A`)
-var IN_NS = regexp.MustCompile(`IN[\s|\t]+NS`)
-var IN_SOA = regexp.MustCompile(`IN[\s|\t]+SOA`)
 
 var splitSpace = regexp.MustCompile(`[\s+|\t+]`)
 var StartsWithLetterOrNumber = regexp.MustCompile(`^\w`)
 var StartsWithWhiteSpace = regexp.MustCompile(`^\s+\S`)
-var commentToEndOfLine = regexp.MustCompile(`;.*`)
 
 //
 // helper functions
@@ -82,23 +247,185 @@ func fqdn(host, domain string) string {
 	return fqdn
 }
 
-func atoui64(v string) uint64 {
-	var u uint64
-	var err error
-	if u, err = strconv.ParseUint(v, 10, 64); err != nil {
+func atoui32(v string) (uint32, error) {
+	u, err := strconv.ParseUint(v, 10, 32)
+	return uint32(u), err
+}
+
+// Parse a BIND-style TTL, either plain seconds or a sequence of numbers
+// with w, d, h, m or s unit suffixes such as "1h30m".
+func parseTTL(v string) (uint64, error) {
+	var total, n uint64
+	var digits bool
+
+	units := map[byte]uint64{'w': 604800, 'd': 86400, 'h': 3600, 'm': 60, 's': 1}
+
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c >= '0' && c <= '9' {
+			n = n*10 + uint64(c-'0')
+			digits = true
+		} else if mult, ok := units[c|0x20]; ok && digits {
+			total += n * mult
+			n, digits = 0, false
+		} else {
+			return 0, fmt.Errorf("invalid TTL %q", v)
+		}
+		if n > math.MaxUint32 || total > math.MaxUint32 {
+			return 0, fmt.Errorf("TTL %q out of range", v)
+		}
+	}
+	if v == "" {
+		return 0, fmt.Errorf("empty TTL")
+	}
+
+	total += n
+	if total > math.MaxUint32 {
+		return 0, fmt.Errorf("TTL %q out of range", v)
+	}
+	return total, nil
+}
+
+// Format a TTL with unit suffixes, e.g. 5400 as "1h30m".
+func formatTTL(v uint64) string {
+	var t string
+
+	if v == 0 {
+		return "0"
+	}
+	for _, u := range []struct {
+		suffix string
+		secs   uint64
+	}{{"w", 604800}, {"d", 86400}, {"h", 3600}, {"m", 60}, {"s", 1}} {
+		if v >= u.secs {
+			t += fmt.Sprintf("%d%s", v/u.secs, u.suffix)
+			v %= u.secs
+		}
+	}
+	return t
+}
+
+// A problem found in an input zone.  Column is 1-based, or 0 if unknown;
+// Directive is the directive or RR type being parsed.
+type ParseError struct {
+	File      string
+	Line      uint32
+	Column    int
+	Directive string
+	Err       error
+}
+
+func (e *ParseError) Error() string {
+	pos := fmt.Sprintf("%s:%d", e.File, e.Line)
+	if e.Column > 0 {
+		pos += fmt.Sprintf(":%d", e.Column)
+	}
+	if e.Directive != "" {
+		pos += ": " + e.Directive
+	}
+	return pos + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Column of field n, counting from 0, of a raw input line, or 0 if the
+// field isn't on that line, as in a record continued over several lines.
+func column(raw string, n int) int {
+	inField := false
+	for i, c := range unparen(stripComments(raw)) {
+		if unicode.IsSpace(c) {
+			inField = false
+		} else if !inField {
+			if n == 0 {
+				return i + 1
+			}
+			inField = true
+			n--
+		}
+	}
+	return 0
+}
+
+// Report a problem in an input zone.  Without -k this is fatal; with -k
+// the error is saved and parsing continues so that every problem can be
+// reported in one pass.
+func parseError(file string, line uint32, col int, directive string, format string, a ...any) {
+	err := &ParseError{file, line, col, directive, fmt.Errorf(format, a...)}
+	if !keepGoing {
 		fmt.Fprintf(os.Stderr, "Parse Error: %s\n", err)
-		os.Exit(1)
+		exit(1)
 	}
-	return u
+	parseErrors = append(parseErrors, err)
+}
+
+// BIND keeps pending dynamic updates for a zone in "<zonefile>.jnl".
+func hasJournal(file string) bool {
+	_, err := os.Stat(file + ".jnl")
+	return err == nil
 }
 
+// Remove a trailing comment, ignoring semicolons inside quoted strings.
 func stripComments(line string) string {
-	commentIndex := strings.IndexByte(line, ';')
-	if commentIndex == -1 {
-		return line
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+
+	return line
+}
+
+// Depth of the parentheses left open at the end of s, ignoring any inside
+// quoted strings.
+func parenDepth(s string) int {
+	depth := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '(':
+			if !quoted {
+				depth++
+			}
+		case ')':
+			if !quoted {
+				depth--
+			}
+		}
 	}
+	return depth
+}
 
-	return line[:commentIndex]
+// Replace the grouping parentheses of a multi-line record with spaces.
+func unparen(s string) string {
+	b := []byte(s)
+	quoted := false
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '(', ')':
+			if !quoted {
+				b[i] = ' '
+			}
+		}
+	}
+	return string(b)
 }
 
 // Save NS RR's, ensuring that each is added only once.
@@ -122,6 +449,89 @@ func isInNS(ns string) bool {
 	return false
 }
 
+//
+// SOA serial number arithmetic (RFC 1982)
+//
+
+// Add n to serial s, wrapping around at 2^32.  RFC 1982 only defines
+// addition for n in the range [0, 2^31-1].
+func SerialAdd(s, n uint32) (uint32, error) {
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("serial increment %d out of range", n)
+	}
+	return s + n, nil
+}
+
+// Compare two serials, returning -1, 0 or 1 if s1 is less than, equal to
+// or greater than s2.  Serials exactly 2^31 apart are incomparable.
+func SerialCompare(s1, s2 uint32) (int, error) {
+	switch {
+	case s1 == s2:
+		return 0, nil
+	case s1-s2 == 1<<31:
+		return 0, fmt.Errorf("serials %d and %d are incomparable", s1, s2)
+	case s1-s2 < 1<<31:
+		return 1, nil
+	default:
+		return -1, nil
+	}
+}
+
+// Increment a serial by one, wrapping around at 2^32.
+func BumpSerial(s uint32) uint32 {
+	n, _ := SerialAdd(s, 1)
+	return n
+}
+
+// Advance a serial using the YYYYMMDDnn convention: the first serial for
+// date t if that is newer than s, otherwise s incremented by one.
+func DateSerial(s uint32, t time.Time) uint32 {
+	d, _ := strconv.ParseUint(t.Format("20060102")+"00", 10, 32)
+	if c, err := SerialCompare(uint32(d), s); err == nil && c > 0 {
+		return uint32(d)
+	}
+	return BumpSerial(s)
+}
+
+// Advance a serial to the Unix time t if that is newer than s, otherwise
+// increment s by one.
+func UnixSerial(s uint32, t time.Time) uint32 {
+	u := uint32(t.Unix())
+	if c, err := SerialCompare(u, s); err == nil && c > 0 {
+		return u
+	}
+	return BumpSerial(s)
+}
+
+// The serial to write to an output file.  Apart from keep, the -serial
+// modes advance the newer of the forward zone's serial and the one in the
+// existing output file, so secondaries see every regeneration as a change.
+func outputSerial(forward uint32, existing string) uint32 {
+	if serialMode == "keep" {
+		return forward
+	}
+
+	s := forward
+	if existing != "" {
+		old, ok, err := readSerial(existing)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading serial: %v\n", err)
+			exit(1)
+		}
+		if c, err := SerialCompare(old, s); ok && err == nil && c > 0 {
+			s = old
+		}
+	}
+
+	switch serialMode {
+	case "date":
+		return DateSerial(s, time.Now())
+	case "unixtime":
+		return UnixSerial(s, time.Now())
+	}
+	return BumpSerial(s)
+}
+
 // Find the common domain between two different hostnames
 func commonDomain(h1, h2 string) string {
 	var common string
@@ -154,239 +564,1310 @@ func commonDomain(h1, h2 string) string {
 	return common
 }
 
-// Convert a $GENERATE directive for A records to a $GENERATE directive for PTR records.
-func ConvertGenerate(directive string) (string, error) {
-	parts := strings.Fields(directive)
-	if parts[0] != "$GENERATE" || (len(parts) < 6 && parts[3] == "IN" && parts[4] != "A") {
-		return "", fmt.Errorf("invalid $GENERATE directive")
+// The first n nibbles of an address, in reverse order and each followed
+// by a dot, as used in ip6.arpa names.
+func reverseNibbles(a []byte, n int) string {
+	const hex = "0123456789abcdef"
+
+	var b strings.Builder
+	for i := n - 1; i >= 0; i-- {
+		v := a[i/2]
+		if i%2 == 0 {
+			v >>= 4
+		}
+		b.WriteByte(hex[v&0xf])
+		b.WriteByte('.')
 	}
+	return b.String()
+}
 
-	// Parse the range
-	rangeParts := strings.Split(parts[1], "-")
-	if len(rangeParts) != 2 {
-		return "", fmt.Errorf("invalid range in $GENERATE directive")
+// Reverse name for an address, e.g. 4.3.2.1.in-addr.arpa.  IPv4 addresses
+// must be in their 4 byte form.  Addresses in a -cidr delegation are
+// named under its classless zone, e.g. 70.64/26.2.0.192.in-addr.arpa.
+func reverseName(addr net.IP) string {
+	if n := classlessNet(addr); n != nil {
+		return fmt.Sprintf("%d.%s", addr[3], classlessOrigin(n))
 	}
-	start, err := strconv.Atoi(rangeParts[0])
-	if err != nil {
-		return "", fmt.Errorf("invalid start value in range")
+	if len(addr) == net.IPv4len {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", addr[3], addr[2], addr[1], addr[0])
 	}
-	stopStep := strings.Split(rangeParts[1], "/")
-	stop, err := strconv.Atoi(stopStep[0])
-	if err != nil {
-		return "", fmt.Errorf("invalid stop value in range")
+	return reverseNibbles(addr, 32) + "ip6.arpa."
+}
+
+// The -cidr network containing addr, if any.  Networks can't overlap, so
+// there is at most one.
+func cidrNet(addr net.IP) *net.IPNet {
+	if len(addr) != net.IPv4len {
+		return nil
 	}
-	step := 1
-	if len(stopStep) == 2 {
-		step, err = strconv.Atoi(stopStep[1])
-		if err != nil {
-			return "", fmt.Errorf("invalid step value in range")
+	for _, n := range cidrs {
+		if n.Contains(addr) {
+			return n
 		}
 	}
+	return nil
+}
 
-	// Parse LHS and RHS
-	lhs := parts[2]
-	rhsTemplate := parts[len(parts)-1]
+// RFC 2317 delegations have prefixes longer than /24
+func isClassless(n *net.IPNet) bool {
+	ones, _ := n.Mask.Size()
+	return ones > 24
+}
 
-	ptrDirective := fmt.Sprintf("$GENERATE %d-%d", start, stop)
-	if step != 1 {
-		ptrDirective += fmt.Sprintf("/%d", step)
+// The -cidr classless delegation containing addr, if any
+func classlessNet(addr net.IP) *net.IPNet {
+	if n := cidrNet(addr); n != nil && isClassless(n) {
+		return n
 	}
+	return nil
+}
 
-	rhsParts := strings.Split(rhsTemplate, ".")
-	if len(rhsParts) != 4 {
-		return "", fmt.Errorf("invalid IP address format in template")
+// Whether the output has the covering zone's records for a -cidr network:
+// it must be a classless delegation, and the covering /24 must pass
+// -only-zone and -only-origin.
+func hasDelegation(n *net.IPNet) bool {
+	if !isClassless(n) {
+		return false
+	}
+	if len(onlyNets) > 0 && !onlyNets.contains(n.IP) {
+		return false
 	}
+	if len(onlyOrigins) > 0 && !onlyOrigins.matches(octetOrigin(n.IP, 24)) {
+		return false
+	}
+	return true
+}
 
-	reverseTemplate := fmt.Sprintf("%s", rhsParts[3])
-	ptrDirective += fmt.Sprintf(" %s IN PTR %s", reverseTemplate, fqdn(lhs, soa.domain))
+// in-addr.arpa zone for the first bits/8 octets of a, e.g. 0.192.in-addr.arpa.
+func octetOrigin(a net.IP, bits int) string {
+	var b strings.Builder
+	for i := bits/8 - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%d.", a[i])
+	}
+	b.WriteString("in-addr.arpa.")
+	return b.String()
+}
 
-	return ptrDirective, nil
+// RFC 2317 zone name for a delegation, e.g. 64/26.2.0.192.in-addr.arpa.
+func classlessOrigin(n *net.IPNet) string {
+	ones, _ := n.Mask.Size()
+	a := n.IP
+	return fmt.Sprintf("%d/%d.%d.%d.%d.in-addr.arpa.", a[3], ones, a[2], a[1], a[0])
 }
 
-func processMkarpaDirecive(s string) {
-	if strings.HasPrefix(s, ";$reverse-domain ") && domain == "" {
-		fields := strings.Fields(s)
-		rd := fields[1]
-		rdlen := len(rd)
-		if rdlen > 0 && rd[rdlen-1] != '.' {
-			rd += "."
+// The reverse zone an address belongs in: its -cidr network, the origin
+// from -d or a ;$reverse-domain directive if it covers the address,
+// otherwise the in-addr.arpa zone at -v4-boundary or the ip6.arpa zone at
+// -v6-boundary.
+func reverseOrigin(addr net.IP) string {
+	if n := cidrNet(addr); n != nil {
+		if isClassless(n) {
+			return classlessOrigin(n)
 		}
-		zone.PushBack(fmt.Sprintf("$ORIGIN %s", rd))
+		ones, _ := n.Mask.Size()
+		return octetOrigin(n.IP, ones)
 	}
+	if directiveOrigin != "" && relativeName(reverseName(addr), directiveOrigin) != reverseName(addr) {
+		return directiveOrigin
+	}
+	if len(addr) == net.IPv4len {
+		return octetOrigin(addr, v4Boundary)
+	}
+	return reverseNibbles(addr, v6Boundary/4) + "ip6.arpa."
 }
 
-// SOA
-func (s *soa_t) String() string {
-	t := fmt.Sprintf("@\tIN\tSOA\t%s\t%s.%s (\n",
-		s.authns, s.contact, s.domain)
-	t += fmt.Sprintf("\t\t\t\t%d\t ; Serial\n", s.serial)
-	t += fmt.Sprintf("\t\t\t\t%d\t\t ; Refresh\n", s.refresh)
-	t += fmt.Sprintf("\t\t\t\t%d\t\t ; Retry\n", s.retry)
-	t += fmt.Sprintf("\t\t\t\t%d\t\t ; Expire\n", s.expire)
-	t += fmt.Sprintf("\t\t\t\t%d )\t\t ; Minimum\n", s.minimum)
-	for _, ns := range s.ns {
-		t += fmt.Sprintf("\t\tIN\tNS\t%s\n", ns)
+// Whether the filter flags rule out a PTR for host at addr: it is not of
+// the -family, in an -exclude-net network, or outside every -only-zone
+// network; or its host matches an -exclude-host pattern; or its reverse
+// zone matches no -only-origin pattern.
+func excluded(addr net.IP, host string) bool {
+	if (family == "4" && len(addr) != net.IPv4len) || (family == "6" && len(addr) == net.IPv4len) {
+		return true
 	}
-	return t
+	if excludeNets.contains(addr) {
+		return true
+	}
+	if len(onlyNets) > 0 && !onlyNets.contains(addr) {
+		return true
+	}
+	if excludeHosts.matches(host) {
+		return true
+	}
+	if len(onlyOrigins) > 0 && !onlyOrigins.matches(reverseOrigin(addr)) {
+		return true
+	}
+	return false
 }
 
-func parseSOA(s string, r *bufio.Reader) {
-	var domain string
-	var contact string
-	var authns string
-
-	splits := strings.Fields(s)
-	if len(splits) == 6 && splits[1] == "IN" { // No TTL in SOA
-		authns = splits[3]
-		contact = splits[4]
-	} else { // TTL in SOA
-		if ttl == "" {
-			ttl = "$TTL " + splits[1]
-		}
-		authns = splits[4]
-		contact = splits[5]
+// Owner name to write for a record: relative to its zone's $ORIGIN, or
+// fully qualified with -fqdn.
+func ownerName(name, origin string) string {
+	if fullyQualified {
+		return name
 	}
+	return relativeName(name, origin)
+}
 
-	contact, domain = removeFirstField(contact, ".")
-	soa.domain = commonDomain(domain, soa.domain)
-	soa.contact = contact
-	soa.authns = authns
-	saveNS(authns)
-
-	t, err := r.ReadString(')')
-	if err != nil {
-		panic(err)
+// Name relative to origin, or unchanged if origin doesn't contain it.
+func relativeName(name, origin string) string {
+	if origin == "" {
+		return name
 	}
-	t = commentToEndOfLine.ReplaceAllString(t, "")
-	tlist := strings.Split(t, "\n")
-	for i := range tlist {
-		tlist[i] = strings.TrimSuffix(tlist[i], ")")
-		tlist[i] = strings.TrimSpace(tlist[i])
-
+	if strings.EqualFold(name, origin) {
+		return "@"
 	}
-
-	soa.serial = atoui64(tlist[0])
-	soa.refresh = atoui64(tlist[1])
-	soa.retry = atoui64(tlist[2])
-	soa.expire = atoui64(tlist[3])
-	soa.minimum = atoui64(tlist[4])
+	if len(name) > len(origin) && strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(origin)) {
+		return name[:len(name)-len(origin)-1]
+	}
+	return name
 }
 
-// Zonefile parsing
-func parseOneZone(r *bufio.Reader) {
-	var lastHost string
-	var line uint32
+// Format v in BIND's $GENERATE nibble format: hex digits, least
+// significant first, separated by dots.  As in BIND, width counts every
+// output character including the dots.
+func nibbles(v, width int, upper bool) string {
+	digits := "0123456789abcdef"
+	if upper {
+		digits = "0123456789ABCDEF"
+	}
 
+	var b strings.Builder
 	for {
-		line++
-		s, err := r.ReadString('\n')
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+		b.WriteByte(digits[v&0xf])
+		v >>= 4
+		if width > 0 {
+			width--
+		}
+		if width > 0 || v != 0 {
+			b.WriteByte('.')
+			if width > 0 {
+				width--
 			}
-			fmt.Fprintf(os.Stderr, "IO Error: Line %d: %s\n", line, err)
-			os.Exit(1)
 		}
-
-		s = strings.TrimSpace(s)
-
-		// Look for mkarpa directives.  They are of the form "^;$<directive> options
-		if strings.HasPrefix(s, ";$") {
-			processMkarpaDirecive(s)
-			continue
+		if v == 0 && width == 0 {
+			break
 		}
+	}
+	return b.String()
+}
 
-		if strings.HasPrefix(s, ";") || strings.HasPrefix(s, "\n") || strings.HasPrefix(s, "$ORIGIN") {
+// Substitute the iterator value i into a $GENERATE template.  Supports
+// '$', '${offset[,width[,base]]}' with base d, o, x, X, n or N, and '\$'
+// for a literal dollar sign.
+func expandTemplate(tmpl string, i int) (string, error) {
+	var b strings.Builder
+
+	for n := 0; n < len(tmpl); n++ {
+		c := tmpl[n]
+		if c == '\\' && n+1 < len(tmpl) && tmpl[n+1] == '$' {
+			b.WriteByte('$')
+			n++
 			continue
 		}
-
-		show := okToShow(s)
-
-		s = stripComments(s)
-
-		if strings.HasPrefix(s, "$GENERATE") {
-			s, err := ConvertGenerate(s)
-			if err == nil {
-				zone.PushBack(s)
-			}
+		if c != '$' {
+			b.WriteByte(c)
 			continue
 		}
-
-		if strings.HasPrefix(s, "$INCLUDE") {
-			splits := strings.Fields(s)
-			zone.PushBack("\n; Processed from $INCLUDE file " + splits[1])
-			parseZone(splits[1])
+		if n+1 >= len(tmpl) || tmpl[n+1] != '{' {
+			b.WriteString(strconv.Itoa(i))
 			continue
 		}
 
-		if strings.HasPrefix(s, "$TTL") {
-			ttl = s
-			continue
+		end := strings.IndexByte(tmpl[n:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated modifier in template %q", tmpl)
 		}
+		mods := strings.Split(tmpl[n+2:n+end], ",")
+		n += end
 
-		if IN_SOA.MatchString(s) {
-			parseSOA(s, r)
-			lastHost = "SOA"
-			continue
+		offset, width, base := 0, 0, "d"
+		var err error
+		if offset, err = strconv.Atoi(mods[0]); err != nil {
+			return "", fmt.Errorf("invalid offset in template %q", tmpl)
+		}
+		if len(mods) > 1 {
+			if width, err = strconv.Atoi(mods[1]); err != nil {
+				return "", fmt.Errorf("invalid width in template %q", tmpl)
+			}
+		}
+		if len(mods) > 2 {
+			base = mods[2]
 		}
 
-		// Save nameservers lists as part of SOA RR
-		if IN_NS.MatchString(s) && lastHost == "SOA" {
-			splits := strings.Fields(s)
-			saveNS(splits[2])
-			continue
+		switch base {
+		case "d", "o", "x", "X":
+			b.WriteString(fmt.Sprintf("%0*"+base, width, i+offset))
+		case "n", "N":
+			if i+offset < 0 {
+				return "", fmt.Errorf("negative value in nibble template %q", tmpl)
+			}
+			b.WriteString(nibbles(i+offset, width, base == "N"))
+		default:
+			return "", fmt.Errorf("unsupported format %q in template %q", base, tmpl)
 		}
+	}
 
-		// Looking at a complete A RR "host IN A 1.2.3.4"
-		if StartsWithLetterOrNumber.MatchString(s) && IN_A.MatchString(s) {
-			var addr string
+	return b.String(), nil
+}
 
-			i := strings.IndexAny(s, ";")
-			if i != -1 {
-				s = s[0:i]
-			}
+// PTR
+func (p *ptr_t) String() string {
+	return fmt.Sprintf("%s\t%s\tIN\tPTR\t\t%s", ownerName(reverseName(p.addr), p.origin), recordTTL(p.ttl), p.host)
+}
 
-			splits := strings.Fields(s)
-			switch len(splits) {
-			case 4:
-				lastHost = fqdn(splits[0], soa.domain)
-				addr = splits[3]
-			default:
-				fmt.Fprintf(os.Stderr, "Parse Error: line %d\n", line)
-				fmt.Fprintf(os.Stderr, "Line: %s\n", s)
-				os.Exit(1)
-			}
+func (p *ptr_t) zone() string {
+	return p.origin
+}
 
-			if show {
-				s := strings.Split(addr, ".")
-				zone.PushBack(fmt.Sprintf("%s\t\tIN\tPTR\t\t%s", s[3], lastHost))
-			} else {
-				// Check if current host is an identified NS, add an A RR if so.
-				if isInNS(lastHost) {
-					NS_A_RR = fmt.Sprintf("%s\t\tIN\tA\t%s ;inaddr", lastHost, addr)
-				}
-			}
-			continue
-		}
-	}
+// Identity of a PTR record, for detecting duplicates
+func (p *ptr_t) key() string {
+	return reverseName(p.addr) + " " + strings.ToLower(p.host)
 }
 
-func parseZone(inputFile string) {
+// $GENERATE
+func (g *generate_t) String() string {
+	t := fmt.Sprintf("$GENERATE %d-%d", g.start, g.stop)
+	if g.step != 1 && g.stop != g.start {
+		t += fmt.Sprintf("/%d", g.step)
+	}
+	t += " " + ownerName(g.owner, g.origin)
+	if v := recordTTL(g.ttl); v != "" {
+		t += " " + v
+	}
+	t += fmt.Sprintf(" IN PTR %s", g.host)
+	return t
+}
 
-	in, err := os.Open(inputFile)
-	if err != nil {
-		fmt.Printf("Error opening input file: %v\n", err)
-		os.Exit(1)
+// TTL to write on a generated record: none if it matches the zone's $TTL
+// or -ptr-ttl is in force.
+func recordTTL(t string) string {
+	if uniformTTL || t == outputTTL {
+		return ""
 	}
+	return t
+}
 
-	r := bufio.NewReader(in)
-	parseOneZone(r)
-	in.Close()
+func (g *generate_t) zone() string {
+	return g.origin
 }
 
-// Generate reverse zone file
-func mkarpa(out *os.File, inputNames []string) {
+// Identity of a $GENERATE directive, for detecting duplicates
+func (g *generate_t) key() string {
+	return strings.ToLower(fmt.Sprintf("%d-%d/%d %s %s", g.start, g.stop, g.step, g.owner, g.host))
+}
+
+// Expand a PTR $GENERATE directive into the records it would create.
+func (g *generate_t) expand() ([]ptrName_t, error) {
+	var ptrs []ptrName_t
+
+	for i := g.start; i <= g.stop; i += g.step {
+		name, err := expandTemplate(g.owner, i)
+		if err != nil {
+			return nil, err
+		}
+		host, err := expandTemplate(g.host, i)
+		if err != nil {
+			return nil, err
+		}
+		ptrs = append(ptrs, ptrName_t{name, host})
+	}
+
+	return ptrs, nil
+}
+
+// Count the addresses and hosts in a $GENERATE range that are excluded by
+// the filter flags.
+func (g *generate_t) excluded() (n, total int, err error) {
+	for i := g.start; i <= g.stop; i += g.step {
+		addr, err := expandTemplate(g.addr, i)
+		if err != nil {
+			return 0, 0, err
+		}
+		host, err := expandTemplate(g.host, i)
+		if err != nil {
+			return 0, 0, err
+		}
+		ip := net.ParseIP(addr)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if ip != nil && excluded(ip, host) {
+			n++
+		}
+		total++
+	}
+	return n, total, nil
+}
+
+// Returned by ConvertGenerate for directives that generate other record types
+var errNotA = errors.New("not an A or AAAA record $GENERATE directive")
+
+// Convert a $GENERATE directive for A or AAAA records to $GENERATE
+// directives for PTR records, one for each reverse zone the addresses
+// fall in.
+func ConvertGenerate(directive, origin string) ([]*generate_t, error) {
+	parts := strings.Fields(directive)
+	if len(parts) < 5 || !strings.EqualFold(parts[0], "$GENERATE") {
+		return nil, fmt.Errorf("invalid $GENERATE directive")
+	}
+	recTTL, _, rrtype, rdata, ok := splitRR(parts[3:])
+	if !ok || len(rdata) == 0 {
+		return nil, fmt.Errorf("invalid $GENERATE directive")
+	}
+	if rrtype != "A" && rrtype != "AAAA" {
+		return nil, errNotA
+	}
+	if len(rdata) != 1 {
+		return nil, fmt.Errorf("invalid A record template")
+	}
+
+	// Parse the range
+	rangeParts := strings.Split(parts[1], "-")
+	if len(rangeParts) != 2 {
+		return nil, fmt.Errorf("invalid range in $GENERATE directive")
+	}
+	start, err := strconv.Atoi(rangeParts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid start value in range")
+	}
+	stopStep := strings.Split(rangeParts[1], "/")
+	stop, err := strconv.Atoi(stopStep[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid stop value in range")
+	}
+	step := 1
+	if len(stopStep) == 2 {
+		step, err = strconv.Atoi(stopStep[1])
+		if err != nil || step < 1 {
+			return nil, fmt.Errorf("invalid step value in range")
+		}
+	}
+	if stop < start {
+		return nil, fmt.Errorf("invalid range in $GENERATE directive")
+	}
+
+	// Parse LHS and RHS
+	lhs := parts[2]
+	rhsTemplate := parts[len(parts)-1]
+
+	g := &generate_t{start: start, stop: stop, step: step, host: fqdn(lhs, origin), addr: rhsTemplate}
+	if recTTL != "" {
+		v, err := parseTTL(recTTL)
+		if err != nil {
+			return nil, err
+		}
+		g.ttl = strconv.FormatUint(v, 10)
+	}
+	if rrtype == "AAAA" {
+		return []*generate_t{g}, convertGenerate6(g, rhsTemplate)
+	}
+	return convertGenerate4(g, rhsTemplate)
+}
+
+// Fill in the owners of an A-derived $GENERATE directive, splitting it
+// where its addresses cross into another reverse zone, such as the next
+// /24 or a -cidr delegation.  The iterator may appear in any octet, but
+// every octet must expand to a plain decimal number from 0 to 255.
+func convertGenerate4(g *generate_t, tmpl string) ([]*generate_t, error) {
+	octets := strings.Split(tmpl, ".")
+	if len(octets) != 4 {
+		return nil, fmt.Errorf("invalid IP address format in template")
+	}
+
+	// Runs of consecutive addresses in the same zone, and which of their
+	// octets change
+	type run_t struct {
+		g      *generate_t
+		first  net.IP
+		varies [4]bool
+	}
+	var runs []*run_t
+	var run *run_t
+
+	for i := g.start; i <= g.stop; i += g.step {
+		addr := make(net.IP, net.IPv4len)
+		for j, o := range octets {
+			v, err := expandTemplate(o, i)
+			if err != nil {
+				return nil, err
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > 255 || strconv.Itoa(n) != v {
+				return nil, fmt.Errorf("template gives octet %q for %d; want a decimal number from 0 to 255", v, i)
+			}
+			addr[j] = byte(n)
+		}
+
+		origin := reverseOrigin(addr)
+		if run != nil && run.g.origin == origin {
+			run.g.stop = i
+			for j := range addr {
+				run.varies[j] = run.varies[j] || addr[j] != run.first[j]
+			}
+			continue
+		}
+
+		run = &run_t{g: new(generate_t), first: addr}
+		*run.g = *g
+		run.g.start, run.g.stop, run.g.origin = i, i, origin
+		runs = append(runs, run)
+	}
+
+	// Octets that are the same throughout a run are written as numbers,
+	// so the owner can be made relative to the run's zone
+	var gens []*generate_t
+	for _, r := range runs {
+		var o [4]string
+		for j := range o {
+			o[j] = octets[j]
+			if !r.varies[j] {
+				o[j] = strconv.Itoa(int(r.first[j]))
+			}
+		}
+		if n := classlessNet(r.first); n != nil {
+			r.g.owner = o[3] + "." + classlessOrigin(n)
+		} else {
+			r.g.owner = fmt.Sprintf("%s.%s.%s.%s.in-addr.arpa.", o[3], o[2], o[1], o[0])
+		}
+		gens = append(gens, r.g)
+	}
+
+	return gens, nil
+}
+
+// Fill in the owner of an AAAA-derived $GENERATE directive.  Only
+// templates whose last group is the iterator in hex, "prefix:${o,w,x}",
+// can be expressed as PTRs: the owner becomes the iterator in nibble
+// format under the prefix's ip6.arpa name.
+func convertGenerate6(g *generate_t, tmpl string) error {
+	i := strings.LastIndex(tmpl, "${")
+	if i == -1 || !strings.HasSuffix(tmpl, "}") || !strings.HasSuffix(tmpl[:i], ":") || strings.Contains(tmpl[:i], "$") {
+		return fmt.Errorf("AAAA template must end in a ${offset,width,x} group")
+	}
+
+	mods := strings.Split(tmpl[i+2:len(tmpl)-1], ",")
+	offset, err := strconv.Atoi(mods[0])
+	if err != nil || len(mods) != 3 || (mods[2] != "x" && mods[2] != "X") {
+		return fmt.Errorf("AAAA template must end in a ${offset,width,x} group")
+	}
+	if g.start+offset < 0 || g.stop+offset > 0xffff {
+		return fmt.Errorf("$GENERATE range overflows the last address group")
+	}
+
+	base := net.ParseIP(tmpl[:i] + "0")
+	if base == nil || base.To4() != nil {
+		return fmt.Errorf("invalid IPv6 address format in template")
+	}
+	if v6Boundary > 112 {
+		return fmt.Errorf("-v6-boundary /%d splits the $GENERATE range", v6Boundary)
+	}
+
+	g.owner = fmt.Sprintf("${%d,7,n}.%sip6.arpa.", offset, reverseNibbles(base, 28))
+	g.origin = reverseOrigin(base)
+	return nil
+}
+
+// Split the fields that follow an owner name into TTL, class, type and
+// RDATA.  The TTL and class are optional and may appear in either order.
+// Class and type are case-insensitive and returned in upper case.
+func splitRR(fields []string) (ttl, class, rrtype string, rdata []string, ok bool) {
+	for i, f := range fields {
+		u := strings.ToUpper(f)
+		switch {
+		case ttl == "" && f[0] >= '0' && f[0] <= '9':
+			ttl = f
+		case class == "" && (u == "IN" || u == "CH" || u == "HS" || strings.HasPrefix(u, "CLASS")):
+			class = u
+			if class == "CLASS1" { // RFC 3597 generic name for IN
+				class = "IN"
+			}
+		default:
+			return ttl, class, u, fields[i+1:], true
+		}
+	}
+	return "", "", "", nil, false
+}
+
+// Read the PTR records from an existing reverse zone.  Parenthesised
+// records are joined onto one line and $GENERATE directives are expanded.
+func readReverseZone(file string) ([]ptrName_t, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseReverseZone(file, data)
+}
+
+// Parse the PTR records in reverse zone file data.
+func parseReverseZone(file string, data []byte) ([]ptrName_t, error) {
+	var ptrs []ptrName_t
+	var origin, owner, pending string
+	var line int
+
+	for _, l := range strings.Split(string(data), "\n") {
+		line++
+		if pending != "" {
+			pending += " "
+		}
+		pending += stripComments(l)
+		if parenDepth(pending) > 0 {
+			continue
+		}
+		l, pending = unparen(pending), ""
+
+		fields := strings.Fields(l)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			origin = fqdn(fields[1], origin)
+			continue
+		case "$TTL":
+			continue
+		case "$GENERATE":
+			if len(fields) < 5 || !strings.EqualFold(fields[len(fields)-2], "PTR") {
+				continue
+			}
+			g, err := reverseGenerate(fields, origin)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: %s", file, line, err)
+			}
+			ptrs = append(ptrs, g...)
+			continue
+		case "$INCLUDE":
+			return nil, fmt.Errorf("%s: line %d: $INCLUDE is not supported", file, line)
+		}
+
+		// Records starting with whitespace belong to the previous owner
+		if !StartsWithWhiteSpace.MatchString(l) {
+			owner = fields[0]
+			if owner == "@" {
+				owner = origin
+			}
+			owner = fqdn(owner, origin)
+			fields = fields[1:]
+		}
+
+		_, _, rrtype, rdata, ok := splitRR(fields)
+		if ok && rrtype == "PTR" && len(rdata) == 1 {
+			ptrs = append(ptrs, ptrName_t{owner, fqdn(rdata[0], origin)})
+		}
+	}
+
+	return ptrs, nil
+}
+
+// Serial of the SOA record in an existing zone file.  ok is false if the
+// file doesn't exist.
+func readSerial(file string) (serial uint32, ok bool, err error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	serial, err = parseSerial(file, data)
+	return serial, err == nil, err
+}
+
+// Serial of the SOA record in zone file data.
+func parseSerial(file string, data []byte) (uint32, error) {
+	var pending string
+	for _, l := range strings.Split(string(data), "\n") {
+		if pending != "" {
+			pending += " "
+		}
+		pending += stripComments(l)
+		if parenDepth(pending) > 0 {
+			continue
+		}
+		l, pending = unparen(pending), ""
+
+		fields := strings.Fields(l)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "$") {
+			continue
+		}
+		if !StartsWithWhiteSpace.MatchString(l) {
+			fields = fields[1:]
+		}
+
+		_, _, rrtype, rdata, ok := splitRR(fields)
+		if !ok || rrtype != "SOA" {
+			continue
+		}
+		if len(rdata) != 7 {
+			return 0, fmt.Errorf("%s: malformed SOA record", file)
+		}
+		serial, err := atoui32(rdata[2])
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid serial: %w", file, err)
+		}
+		return serial, nil
+	}
+
+	return 0, fmt.Errorf("%s: no SOA record", file)
+}
+
+// Expand a "$GENERATE range owner [IN] PTR target" line from a reverse zone.
+func reverseGenerate(fields []string, origin string) ([]ptrName_t, error) {
+	var ptrs []ptrName_t
+
+	var start, stop, step int
+	step = 1
+	r := strings.NewReplacer("-", " ", "/", " ")
+	if n, _ := fmt.Sscan(r.Replace(fields[1]), &start, &stop, &step); n < 2 {
+		return nil, fmt.Errorf("invalid range in $GENERATE directive")
+	}
+	if step < 1 {
+		return nil, fmt.Errorf("invalid step value in range")
+	}
+	if stop < start {
+		return nil, fmt.Errorf("invalid range in $GENERATE directive")
+	}
+
+	for i := start; i <= stop; i += step {
+		name, err := expandTemplate(fields[2], i)
+		if err != nil {
+			return nil, err
+		}
+		host, err := expandTemplate(fields[len(fields)-1], i)
+		if err != nil {
+			return nil, err
+		}
+		ptrs = append(ptrs, ptrName_t{fqdn(name, origin), fqdn(host, origin)})
+	}
+
+	return ptrs, nil
+}
+
+// All the PTR records that mkarpa will generate, with fully-qualified
+// owner names.
+func generatedPTRs() ([]ptrName_t, error) {
+	var entries []any
+	for e := zone.Front(); e != nil; e = e.Next() {
+		entries = append(entries, e.Value)
+	}
+	return entryPTRs(entries)
+}
+
+// The PTR records for a list of entries, with $GENERATE ranges expanded.
+func entryPTRs(entries []any) ([]ptrName_t, error) {
+	var ptrs []ptrName_t
+
+	for _, e := range entries {
+		switch v := e.(type) {
+		case *ptr_t:
+			ptrs = append(ptrs, ptrName_t{reverseName(v.addr), v.host})
+		case *generate_t:
+			g, err := v.expand()
+			if err != nil {
+				return nil, err
+			}
+			ptrs = append(ptrs, g...)
+		}
+	}
+
+	return ptrs, nil
+}
+
+// Remove exact duplicate PTR records and $GENERATE directives, such as a
+// host that appears in more than one of the merged input zones.  Returns
+// the entries that were removed.
+func dedupe() []fmt.Stringer {
+	var removed []fmt.Stringer
+
+	seen := make(map[string]bool)
+	for e := zone.Front(); e != nil; {
+		next := e.Next()
+
+		var key string
+		switch v := e.Value.(type) {
+		case *ptr_t:
+			key = v.key()
+		case *generate_t:
+			key = v.key()
+		}
+		if key != "" {
+			if seen[key] {
+				removed = append(removed, zone.Remove(e).(fmt.Stringer))
+			}
+			seen[key] = true
+		}
+
+		e = next
+	}
+
+	return removed
+}
+
+// Find addresses with PTRs to more than one host and apply the -dup-ptr
+// policy to them: keep the first, last or alphabetically first PTR, or
+// all of them.  Returns a report of the collisions.  A PTR can't be
+// removed from a $GENERATE range, so overlaps between ranges and single
+// PTRs are only reported.
+func resolveCollisions(policy string) []string {
+	var report []string
+
+	var names []string
+	byName := make(map[string][]*list.Element)
+	for e := zone.Front(); e != nil; e = e.Next() {
+		if p, ok := e.Value.(*ptr_t); ok {
+			name := strings.ToLower(reverseName(p.addr))
+			if _, seen := byName[name]; !seen {
+				names = append(names, name)
+			}
+			byName[name] = append(byName[name], e)
+		}
+	}
+
+	for e := zone.Front(); e != nil; e = e.Next() {
+		g, ok := e.Value.(*generate_t)
+		if !ok {
+			continue
+		}
+		ptrs, err := g.expand()
+		if err != nil {
+			continue
+		}
+		for _, p := range ptrs {
+			if dups := byName[strings.ToLower(p.name)]; len(dups) > 0 {
+				report = append(report, fmt.Sprintf("%s has a PTR to %s and is in \"%s\"",
+					p.name, dups[0].Value.(*ptr_t).host, g))
+			}
+		}
+	}
+
+	for _, name := range names {
+		dups := byName[name]
+		if len(dups) < 2 {
+			continue
+		}
+
+		var hosts []string
+		keep := dups[0]
+		for _, e := range dups {
+			host := e.Value.(*ptr_t).host
+			hosts = append(hosts, host)
+			switch policy {
+			case "last":
+				keep = e
+			case "alphabetical":
+				if strings.ToLower(host) < strings.ToLower(keep.Value.(*ptr_t).host) {
+					keep = e
+				}
+			}
+		}
+
+		r := fmt.Sprintf("%s has PTRs to %s", name, strings.Join(hosts, ", "))
+		if policy != "all" && policy != "error" {
+			for _, e := range dups {
+				if e != keep {
+					zone.Remove(e)
+				}
+			}
+			r += fmt.Sprintf("; kept %s", keep.Value.(*ptr_t).host)
+		}
+		report = append(report, r)
+	}
+
+	return report
+}
+
+// Compare an existing, hand-maintained reverse zone with the generated
+// records and report which of its PTRs are derivable from the forward
+// zones and which are manual entries that need to be carried forward.
+func adopt(out io.Writer, existing string) {
+	have, err := readReverseZone(existing)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", existing, err)
+		exit(1)
+	}
+
+	gen, err := generatedPTRs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error expanding generated records: %v\n", err)
+		exit(1)
+	}
+
+	derivable := make(map[ptrName_t]bool)
+	for _, p := range gen {
+		derivable[ptrName_t{strings.ToLower(p.name), strings.ToLower(p.host)}] = true
+	}
+
+	var manual []ptrName_t
+	fmt.Fprintf(out, "; Adoption report for %s\n;\n", existing)
+	for _, p := range have {
+		if derivable[ptrName_t{strings.ToLower(p.name), strings.ToLower(p.host)}] {
+			fmt.Fprintf(out, "; derivable\t%s\tIN\tPTR\t%s\n", p.name, p.host)
+		} else {
+			manual = append(manual, p)
+		}
+	}
+
+	fmt.Fprintf(out, ";\n; %d derivable, %d manual PTR records\n", len(have)-len(manual), len(manual))
+	if len(manual) > 0 {
+		fmt.Fprintf(out, "; Manual records to carry forward:\n")
+	}
+	for _, p := range manual {
+		fmt.Fprintf(out, "%s\tIN\tPTR\t%s\n", p.name, p.host)
+	}
+}
+
+// Compare the generated PTRs with those in the existing output files and
+// print the differences: "+" for records that would be added and "-" for
+// ones that would be removed.  Returns the number of differences.
+func diff(out io.Writer, existing []string) int {
+	var have []ptrName_t
+	for _, f := range existing {
+		ptrs, err := readReverseZone(f)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", f, err)
+			exit(1)
+		}
+		have = append(have, ptrs...)
+	}
+
+	gen, err := generatedPTRs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error expanding generated records: %v\n", err)
+		exit(1)
+	}
+
+	key := func(p ptrName_t) ptrName_t {
+		return ptrName_t{strings.ToLower(p.name), strings.ToLower(p.host)}
+	}
+	old := make(map[ptrName_t]bool)
+	for _, p := range have {
+		old[key(p)] = true
+	}
+	cur := make(map[ptrName_t]bool)
+	for _, p := range gen {
+		cur[key(p)] = true
+	}
+
+	n := 0
+	for _, p := range have {
+		if !cur[key(p)] {
+			fmt.Fprintf(out, "- %s\tIN\tPTR\t%s\n", p.name, p.host)
+			cur[key(p)] = true
+			n++
+		}
+	}
+	for _, p := range gen {
+		if !old[key(p)] {
+			fmt.Fprintf(out, "+ %s\tIN\tPTR\t%s\n", p.name, p.host)
+			old[key(p)] = true
+			n++
+		}
+	}
+	return n
+}
+
+func processMkarpaDirecive(s string) {
+	if strings.HasPrefix(s, ";$reverse-domain ") && domain == "" && !v4BoundarySet {
+		fields := strings.Fields(s)
+		rd := fields[1]
+		rdlen := len(rd)
+		if rdlen > 0 && rd[rdlen-1] != '.' {
+			rd += "."
+		}
+		directiveOrigin = rd
+	}
+}
+
+// SOA
+func (s *soa_t) String() string {
+	timer := func(v uint64) string {
+		if ttlUnits {
+			return formatTTL(v)
+		}
+		return strconv.FormatUint(v, 10)
+	}
+
+	t := fmt.Sprintf("@\tIN\tSOA\t%s\t%s.%s (\n",
+		s.authns, s.contact, s.domain)
+	t += fmt.Sprintf("\t\t\t\t%d\t ; Serial\n", s.serial)
+	t += fmt.Sprintf("\t\t\t\t%s\t\t ; Refresh\n", timer(s.refresh))
+	t += fmt.Sprintf("\t\t\t\t%s\t\t ; Retry\n", timer(s.retry))
+	t += fmt.Sprintf("\t\t\t\t%s\t\t ; Expire\n", timer(s.expire))
+	t += fmt.Sprintf("\t\t\t\t%s )\t\t ; Minimum\n", timer(s.minimum))
+	for _, ns := range s.ns {
+		t += fmt.Sprintf("\t\tIN\tNS\t%s\n", ns)
+	}
+	return t
+}
+
+// Parse the TTL and data of an SOA record, already joined onto one line.
+func parseSOA(file string, line uint32, origin, soaTTL string, rdata []string) {
+	var domain string
+	var contact string
+	var authns string
+
+	if len(rdata) != 7 {
+		parseError(file, line, 0, "SOA", "malformed record")
+		return
+	}
+	if soaTTL != "" && ttl == "" {
+		ttl = "$TTL " + soaTTL
+	}
+	authns = rdata[0]
+	contact = rdata[1]
+
+	authns = fqdn(authns, origin)
+	contact = fqdn(contact, origin)
+	if !strings.Contains(contact, ".") {
+		parseError(file, line, 0, "SOA", "invalid contact %s", contact)
+		return
+	}
+
+	first := soa.authns == ""
+
+	contact, domain = removeFirstField(contact, ".")
+	soa.domain = commonDomain(domain, soa.domain)
+	soa.contact = contact
+	soa.authns = authns
+	saveNS(authns)
+
+	// When merging several forward zones, keep the most recent serial.
+	serial, err := atoui32(rdata[2])
+	if err != nil {
+		parseError(file, line, 0, "SOA", "invalid serial: %w", err)
+	} else if c, err := SerialCompare(serial, soa.serial); first || (err == nil && c > 0) {
+		soa.serial = serial
+	}
+
+	timers := []*uint64{&soa.refresh, &soa.retry, &soa.expire, &soa.minimum}
+	for i, p := range timers {
+		if *p, err = parseTTL(rdata[i+3]); err != nil {
+			parseError(file, line, 0, "SOA", "invalid timer: %w", err)
+		}
+	}
+}
+
+// The origin relative names are qualified with: the current $ORIGIN, or
+// failing that the domain taken from the SOA.
+func zoneOrigin(origin string) string {
+	if origin == "" {
+		return soa.domain
+	}
+	return origin
+}
+
+// Zonefile parsing.  origin is the $ORIGIN in effect at the start of the
+// file; changes made by the file don't outlive it.
+func parseOneZone(file, origin string, r *bufio.Reader) {
+	var lastHost string
+	var owner string
+	var line, continued uint32
+
+	for {
+		line += 1 + continued
+		continued = 0
+		// A final line without a newline still needs processing
+		s, err := r.ReadString('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Fprintf(os.Stderr, "IO Error: Line %d: %s\n", line, err)
+				exit(1)
+			}
+			if s == "" {
+				break
+			}
+		}
+		raw := s
+
+		s = strings.TrimSpace(s)
+
+		// Look for mkarpa directives.  They are of the form "^;$<directive> options
+		if strings.HasPrefix(s, ";$") {
+			processMkarpaDirecive(s)
+			continue
+		}
+
+		if strings.HasPrefix(s, ";") || strings.HasPrefix(s, "\n") {
+			continue
+		}
+
+		show := okToShow(s)
+
+		s = stripComments(s)
+
+		// Join the continuation lines of a multi-line record
+		for parenDepth(s) > 0 {
+			next, err := r.ReadString('\n')
+			if next == "" && err != nil {
+				parseError(file, line, 0, "", "unterminated parenthesis")
+				break
+			}
+			continued++
+			s += " " + stripComments(strings.TrimSpace(next))
+		}
+		s = unparen(s)
+
+		// Directive names are case-insensitive
+		if f := strings.Fields(s); len(f) > 0 && strings.HasPrefix(f[0], "$") {
+			s = strings.ToUpper(f[0]) + s[len(f[0]):]
+		}
+
+		if strings.HasPrefix(s, "$ORIGIN") {
+			splits := strings.Fields(s)
+			if len(splits) != 2 {
+				parseError(file, line, 0, "$ORIGIN", "malformed directive")
+				continue
+			}
+			origin = fqdn(splits[1], origin)
+			continue
+		}
+
+		if strings.HasPrefix(s, "$GENERATE") && show {
+			gens, err := ConvertGenerate(s, zoneOrigin(origin))
+			if err != nil {
+				if !errors.Is(err, errNotA) {
+					parseError(file, line, 0, "$GENERATE", "%s", err)
+				}
+				continue
+			}
+			for _, g := range gens {
+				// Ranges must be excluded all or nothing
+				n, total, err := g.excluded()
+				if err == nil && n > 0 && n < total {
+					err = fmt.Errorf("%d of %d addresses are excluded", n, total)
+				}
+				if err != nil {
+					parseError(file, line, 0, "$GENERATE", "%s", err)
+					continue
+				}
+				if n == total {
+					continue
+				}
+				if g.ttl == "" {
+					g.ttl = currentTTL
+				}
+				zone.PushBack(g)
+			}
+			continue
+		}
+
+		// "$INCLUDE file [origin]"; the included file starts with the given
+		// origin, or ours, and our origin is unchanged afterwards.
+		if strings.HasPrefix(s, "$INCLUDE") {
+			splits := strings.Fields(s)
+			if len(splits) < 2 || len(splits) > 3 {
+				parseError(file, line, 0, "$INCLUDE", "malformed directive")
+				continue
+			}
+			incOrigin := origin
+			if len(splits) == 3 {
+				incOrigin = fqdn(splits[2], origin)
+			}
+			if err := checkInclude(splits[1]); err != nil {
+				parseError(file, line, column(raw, 1), "$INCLUDE", "%s", err)
+				continue
+			}
+			zone.PushBack("\n; Processed from $INCLUDE file " + splits[1])
+			parseZone(splits[1], incOrigin)
+			continue
+		}
+
+		if strings.HasPrefix(s, "$TTL") {
+			ttl = s
+			if f := strings.Fields(s); len(f) > 1 {
+				v, err := parseTTL(f[1])
+				if err != nil {
+					parseError(file, line, column(raw, 1), "$TTL", "%s", err)
+					continue
+				}
+				currentTTL = strconv.FormatUint(v, 10)
+			}
+			continue
+		}
+
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Records starting with whitespace belong to the previous owner.
+		// first is the index in the line of the field after the owner.
+		first := 0
+		if !StartsWithWhiteSpace.MatchString(raw) {
+			owner, fields = fields[0], fields[1:]
+			first = 1
+		}
+
+		// Only IN class records have addresses to reverse
+		recTTL, class, rrtype, rdata, ok := splitRR(fields)
+		if !ok || (class != "" && class != "IN") {
+			continue
+		}
+
+		if rrtype == "SOA" {
+			parseSOA(file, line, zoneOrigin(origin), recTTL, rdata)
+			lastHost = "SOA"
+			continue
+		}
+
+		// Save nameservers lists as part of SOA RR
+		if rrtype == "NS" && lastHost == "SOA" {
+			if len(rdata) != 1 {
+				parseError(file, line, 0, rrtype, "malformed record: %s", s)
+				continue
+			}
+			saveNS(fqdn(rdata[0], zoneOrigin(origin)))
+			continue
+		}
+
+		// Looking at an address RR "host [ttl] [IN] A 1.2.3.4" or AAAA
+		if (rrtype == "A" || rrtype == "AAAA") && (owner == "@" || StartsWithLetterOrNumber.MatchString(owner)) {
+			if len(rdata) != 1 {
+				parseError(file, line, 0, rrtype, "malformed record: %s", s)
+				continue
+			}
+			addr := rdata[0]
+			if owner == "@" {
+				lastHost = zoneOrigin(origin)
+			} else {
+				lastHost = fqdn(owner, zoneOrigin(origin))
+			}
+
+			if show {
+				ip := parseAddr(rrtype, addr)
+				if ip == nil {
+					parseError(file, line, column(raw, first+len(fields)-len(rdata)), rrtype, "invalid address %s", addr)
+					continue
+				}
+				t := currentTTL
+				if recTTL != "" {
+					v, err := parseTTL(recTTL)
+					if err != nil {
+						// The TTL comes first, or after the class
+						n := first
+						if fields[0] != recTTL {
+							n++
+						}
+						parseError(file, line, column(raw, n), rrtype, "%s", err)
+						continue
+					}
+					t = strconv.FormatUint(v, 10)
+				}
+				if families != nil {
+					noteFamily(lastHost, ip)
+				}
+				if !excluded(ip, lastHost) {
+					zone.PushBack(&ptr_t{ip, lastHost, reverseOrigin(ip), t})
+				}
+			} else if rrtype == "A" {
+				// Check if current host is an identified NS, add an A RR if so.
+				if isInNS(lastHost) {
+					NS_A_RR = fmt.Sprintf("%s\t\tIN\tA\t%s ;inaddr", lastHost, addr)
+				}
+			}
+			continue
+		}
+	}
+}
+
+// Address family bits for -dual-stack
+const (
+	hasV4 = 1 << iota
+	hasV6
+)
+
+// Record that host has an address of addr's family.
+func noteFamily(host string, addr net.IP) {
+	host = strings.ToLower(host)
+	if _, seen := families[host]; !seen {
+		familyOrder = append(familyOrder, host)
+	}
+	if len(addr) == net.IPv4len {
+		families[host] |= hasV4
+	} else {
+		families[host] |= hasV6
+	}
+}
+
+// Hosts that have addresses of only one family, with the family they lack.
+// Hosts from $GENERATE ranges aren't checked.
+func singleStack() []string {
+	var report []string
+	for _, host := range familyOrder {
+		switch families[host] {
+		case hasV4:
+			report = append(report, fmt.Sprintf("%s has an A record but no AAAA", host))
+		case hasV6:
+			report = append(report, fmt.Sprintf("%s has an AAAA record but no A", host))
+		}
+	}
+	return report
+}
+
+// Parse the address of an A or AAAA record, returning IPv4 addresses in
+// their 4 byte form.
+func parseAddr(rrtype, addr string) net.IP {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	if rrtype == "A" {
+		return ip.To4()
+	}
+	if ip.To4() != nil {
+		return nil
+	}
+	return ip
+}
+
+// Check that including file won't recurse forever.
+func checkInclude(file string) error {
+	if len(includeChain) > maxIncludeDepth {
+		return fmt.Errorf("includes nested more than %d deep", maxIncludeDepth)
+	}
+
+	abs, _ := filepath.Abs(file)
+	for i, f := range includeChain {
+		if f == abs {
+			return fmt.Errorf("include cycle: %s -> %s", strings.Join(includeChain[i:], " -> "), abs)
+		}
+	}
+	return nil
+}
+
+func parseZone(inputFile, origin string) {
+
+	// "-" reads the zone from stdin; any $INCLUDEs are resolved
+	// relative to the current directory.
+	if inputFile == "-" {
+		parseOneZone("<stdin>", origin, bufio.NewReader(os.Stdin))
+		return
+	}
+
+	if hasJournal(inputFile) {
+		fmt.Fprintf(os.Stderr, "Warning: %s has a journal file; run 'rndc sync' so pending updates are included\n", inputFile)
+	}
+
+	in, err := os.Open(inputFile)
+	if err != nil {
+		fmt.Printf("Error opening input file: %v\n", err)
+		exit(1)
+	}
+
+	abs, _ := filepath.Abs(inputFile)
+	includeChain = append(includeChain, abs)
+
+	r := bufio.NewReader(in)
+	parseOneZone(inputFile, origin, r)
+
+	includeChain = includeChain[:len(includeChain)-1]
+	in.Close()
+}
 
+// Write the comment block, $TTL, SOA and NS glue that start a reverse
+// zone file
+func writeHeader(out io.Writer, inputNames []string) {
 	host, err := os.Hostname()
 	if err != nil {
 		host = "<unknown>"
@@ -399,62 +1880,801 @@ func mkarpa(out *os.File, inputNames []string) {
 	fmt.Fprintf(out, ";\n")
 	fmt.Fprintf(out, "; Generated %s from:\n", time.Now().Format(time.UnixDate))
 	for _, input := range inputNames {
+		if input == "-" {
+			fmt.Fprintf(out, ";  %s:<stdin>\n", host)
+			continue
+		}
 		input, _ = filepath.Abs(input)
 		fmt.Fprintf(out, ";  %s:%s\n", host, input)
 	}
 	fmt.Fprintln(out, ";;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;;")
 	fmt.Fprintf(out, "%s\n", ttl)
-	fmt.Fprintf(out, soa.String())
+	fmt.Fprint(out, soa.String())
 
 	if NS_A_RR != "" {
 		fmt.Fprintf(out, "\n%s\n\n", NS_A_RR)
 	}
+}
+
+// Generate reverse zone file
+func mkarpa(out io.Writer, inputNames []string) {
+	writeHeader(out, inputNames)
 
-	if domain != "" {
+	current := ""
+	if domain != "" && !fullyQualified {
 		fmt.Fprintf(out, "\n$ORIGIN %s\n\n", domain)
+		current = domain
 	}
 
 	for e := zone.Front(); e != nil; e = e.Next() {
+		if r, ok := e.Value.(rr_t); ok && r.zone() != current && !fullyQualified {
+			current = r.zone()
+			fmt.Fprintf(out, "$ORIGIN %s\n", current)
+		}
 		fmt.Fprintln(out, e.Value)
 	}
+
+	writeDelegations(out, current, "")
+}
+
+// File name for a reverse zone: its origin without the trailing dot, and
+// with the "/" of RFC 2317 zone names replaced by "-".
+func zoneFileName(origin string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(origin, "."), "/", "-")
+}
+
+// Group the entries by zone, keeping their order, for -split.  Comments
+// go with the entry that follows them.
+func splitZones() (origins []string, entries map[string][]any) {
+	entries = make(map[string][]any)
+	var pending []any
+	for e := zone.Front(); e != nil; e = e.Next() {
+		r, ok := e.Value.(rr_t)
+		if !ok {
+			pending = append(pending, e.Value)
+			continue
+		}
+		o := r.zone()
+		if _, seen := entries[o]; !seen {
+			origins = append(origins, o)
+		}
+		entries[o] = append(append(entries[o], pending...), e.Value)
+		pending = nil
+	}
+
+	// The covering zone of a delegation needs a file even if it has no
+	// PTRs of its own
+	for _, n := range cidrs {
+		if !hasDelegation(n) {
+			continue
+		}
+		parent := octetOrigin(n.IP, 24)
+		if _, seen := entries[parent]; !seen {
+			origins = append(origins, parent)
+			entries[parent] = nil
+		}
+	}
+
+	return origins, entries
+}
+
+// Generate one reverse zone file per origin, in dir
+func mkarpaSplit(dir string, inputNames []string, force bool) {
+	origins, entries := splitZones()
+
+	forward := soa.serial
+	for _, o := range origins {
+		name := filepath.Join(dir, zoneFileName(o))
+		soa.serial = outputSerial(forward, name)
+
+		var buf bytes.Buffer
+		writeHeader(&buf, inputNames)
+		if !fullyQualified {
+			fmt.Fprintf(&buf, "\n$ORIGIN %s\n\n", o)
+		}
+		for _, v := range entries[o] {
+			fmt.Fprintln(&buf, v)
+		}
+		writeDelegations(&buf, o, o)
+
+		want, err := entryPTRs(entries[o])
+		if err == nil {
+			err = validateZone(name, buf.Bytes(), want)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: generated zone failed validation, not written: %v\n", err)
+			exit(1)
+		}
+		writeOutput(name, force, buf.Bytes())
+	}
+}
+
+// Check generated zone data before it is written: it must parse, have an
+// SOA record and valid names, and hold exactly the expected PTRs.  This
+// guards against a bug in mkarpa corrupting a production zone.
+func validateZone(file string, data []byte, want []ptrName_t) error {
+	if _, err := parseSerial(file, data); err != nil {
+		return err
+	}
+	have, err := parseReverseZone(file, data)
+	if err != nil {
+		return err
+	}
+
+	key := func(p ptrName_t) ptrName_t {
+		return ptrName_t{strings.ToLower(p.name), strings.ToLower(p.host)}
+	}
+	found := make(map[ptrName_t]bool)
+	for _, p := range have {
+		if err := checkName(p.name); err != nil {
+			return fmt.Errorf("%s: PTR owner %s", file, err)
+		}
+		if err := checkName(p.host); err != nil {
+			return fmt.Errorf("%s: PTR target %s", file, err)
+		}
+		found[key(p)] = true
+	}
+
+	expected := make(map[ptrName_t]bool)
+	for _, p := range want {
+		if !found[key(p)] {
+			return fmt.Errorf("%s: PTR %s -> %s is missing", file, p.name, p.host)
+		}
+		expected[key(p)] = true
+	}
+	for _, p := range have {
+		if !expected[key(p)] {
+			return fmt.Errorf("%s: unexpected PTR %s -> %s", file, p.name, p.host)
+		}
+	}
+	return nil
+}
+
+// Check that a name is fully qualified and within the DNS length limits.
+func checkName(name string) error {
+	if !strings.HasSuffix(name, ".") {
+		return fmt.Errorf("%s is not fully qualified", name)
+	}
+	if len(name) > 254 {
+		return fmt.Errorf("%s is longer than 255 bytes", name)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("%s has an empty or over-long label", name)
+		}
+	}
+	return nil
+}
+
+// Write a file, or standard output if name is empty.  The data goes to a
+// temporary file in the same directory, which is synced and then renamed
+// over the old file, so a crash can't leave named a truncated zone.  With
+// -backup the old file is kept as name.YYYYMMDDhhmmss.bak.
+func writeOutput(name string, force bool, data []byte) {
+	if name == "" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			fmt.Printf("Error writing output: %v\n", err)
+			exit(1)
+		}
+		return
+	}
+
+	// A journal means the zone takes dynamic updates; rewriting the
+	// file under named would lose them.
+	if hasJournal(name) && !force {
+		fmt.Fprintf(os.Stderr, "Error: %s has a journal file; run 'rndc freeze' first or use -f\n", name)
+		exit(1)
+	}
+
+	if err := writeAtomic(name, data); err != nil {
+		fmt.Printf("Error writing output file: %v\n", err)
+		exit(1)
+	}
+}
+
+func writeAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Keep the old file's permissions and ownership
+	mode := os.FileMode(0644)
+	old, err := os.Stat(name)
+	if err == nil {
+		mode = old.Mode().Perm()
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil && old != nil {
+		st, ok := old.Sys().(*syscall.Stat_t)
+		if ok && (st.Uid != uint32(os.Getuid()) || st.Gid != uint32(os.Getgid())) {
+			// Only root can give the file away; anyone else keeps the
+			// group if they are in it, and writes the file as themselves
+			err = tmp.Chown(int(st.Uid), int(st.Gid))
+			if errors.Is(err, syscall.EPERM) {
+				if tmp.Chown(-1, int(st.Gid)) != nil {
+					fmt.Fprintf(os.Stderr, "Warning: can't keep the owner and group of %s\n", name)
+				} else if st.Uid != uint32(os.Getuid()) {
+					fmt.Fprintf(os.Stderr, "Warning: can't keep the owner of %s\n", name)
+				}
+				err = nil
+			}
+		}
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if backup && old != nil {
+		if err := backupFile(name, mode); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Keep the current contents of name as name.YYYYMMDDhhmmss.bak, as a hard
+// link if the filesystem allows it and a copy if not.  A backup taken in
+// the same second gets a numbered name, name.YYYYMMDDhhmmss.N.bak, rather
+// than replacing the earlier one.
+func backupFile(name string, mode os.FileMode) error {
+	stamp := name + "." + time.Now().Format("20060102150405")
+	for n := 0; ; n++ {
+		bak := stamp + ".bak"
+		if n > 0 {
+			bak = fmt.Sprintf("%s.%d.bak", stamp, n)
+		}
+
+		err := os.Link(name, bak)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		// link(2) gives EPERM on filesystems without hard links
+		if !errors.Is(err, syscall.EXDEV) && !errors.Is(err, syscall.EPERM) && !errors.Is(err, syscall.ENOTSUP) {
+			return err
+		}
+
+		prev, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(bak, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(prev)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+}
+
+// Write the server configuration declaring each reverse zone and the file
+// it is in: BIND zone statements, or Knot or NSD zone sections.
+func writeServerConf(out io.Writer, format string, origins, files []string) {
+	for i, o := range origins {
+		name := strings.TrimSuffix(o, ".")
+		file, err := filepath.Abs(files[i])
+		if err != nil {
+			file = files[i]
+		}
+
+		switch format {
+		case "bind":
+			fmt.Fprintf(out, "zone \"%s\" {\n\ttype primary;\n\tfile \"%s\";\n};\n\n", name, file)
+		case "knot":
+			if i == 0 {
+				fmt.Fprintf(out, "zone:\n")
+			}
+			fmt.Fprintf(out, "  - domain: %s.\n    file: \"%s\"\n", name, file)
+		case "nsd":
+			fmt.Fprintf(out, "zone:\n\tname: \"%s\"\n\tzonefile: \"%s\"\n\n", name, file)
+		}
+	}
+}
+
+// Write the records the covering /24 zone needs for each -cidr
+// delegation: NS records for the classless zone and a CNAME for every
+// address in it.  Only delegations from parent are written, unless it is
+// empty.  Returns the $ORIGIN in effect afterwards.
+func writeDelegations(out io.Writer, current, parent string) string {
+	for _, n := range cidrs {
+		a := n.IP
+		if !hasDelegation(n) || (parent != "" && parent != octetOrigin(a, 24)) {
+			continue
+		}
+		parent := octetOrigin(a, 24)
+		ones, _ := n.Mask.Size()
+		child := ownerName(classlessOrigin(n), parent)
+		last := int(a[3]) + 1<<(32-ones) - 1
+
+		fmt.Fprintf(out, "\n; RFC 2317 delegation of %s, for the %s zone\n", n, parent)
+		if parent != current && !fullyQualified {
+			fmt.Fprintf(out, "$ORIGIN %s\n", parent)
+			current = parent
+		}
+		for _, ns := range soa.ns {
+			fmt.Fprintf(out, "%s\t\tIN\tNS\t%s\n", child, ns)
+		}
+		fmt.Fprintf(out, "$GENERATE %d-%d %s IN CNAME $.%s\n", a[3], last, ownerName("$."+parent, parent), child)
+	}
+	return current
+}
+
+// Split the elements of a configuration array on the commas that are not
+// inside a quoted string.
+func splitArray(s string) []string {
+	var elems []string
+	var quote rune
+	last := 0
+	for i, c := range s {
+		if (c == '"' || c == '\'') && (quote == 0 || quote == c) {
+			quote ^= c
+		} else if c == ',' && quote == 0 {
+			elems = append(elems, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(elems, s[last:])
+}
+
+// Parse a value from a configuration file: a quoted string, a bare word
+// or number, or an array of those in brackets.
+func configValues(v string) ([]string, error) {
+	if strings.HasPrefix(v, "[") {
+		if !strings.HasSuffix(v, "]") {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		var values []string
+		for _, e := range splitArray(v[1 : len(v)-1]) {
+			e = strings.TrimSpace(e)
+			if e == "" {
+				continue
+			}
+			ev, err := configValues(e)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, ev...)
+		}
+		return values, nil
+	}
+
+	switch {
+	case strings.HasPrefix(v, `"`):
+		u, err := strconv.Unquote(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", v)
+		}
+		return []string{u}, nil
+	case strings.HasPrefix(v, "'"):
+		if len(v) < 2 || !strings.HasSuffix(v, "'") {
+			return nil, fmt.Errorf("invalid string %s", v)
+		}
+		return []string{v[1 : len(v)-1]}, nil
+	}
+	return []string{v}, nil
+}
+
+// Apply a configuration file of "name = value" lines, a small subset of
+// TOML.  Each name is a command line flag, and arrays set repeatable flags
+// more than once; "inputs" lists the forward zones.  Flags given on the
+// command line take precedence.  Returns the inputs.
+func loadConfig(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var inputs []string
+	var pending string
+	var line, start int
+	for _, l := range strings.Split(string(data), "\n") {
+		line++
+		// Strip comments, but not a # in a string
+		var quote rune
+		for i, c := range l {
+			if (c == '"' || c == '\'') && (quote == 0 || quote == c) {
+				quote ^= c
+			} else if c == '#' && quote == 0 {
+				l = l[:i]
+				break
+			}
+		}
+
+		// Arrays may span lines
+		if pending == "" {
+			start = line
+		}
+		pending += strings.TrimSpace(l)
+		if _, value, ok := strings.Cut(pending, "="); ok {
+			value = strings.TrimSpace(value)
+			if strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") {
+				continue
+			}
+		}
+		l, pending = pending, ""
+		if l == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected name = value", file, start)
+		}
+		name = strings.Trim(strings.TrimSpace(name), `"`)
+		values, err := configValues(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", file, start, err)
+		}
+
+		if name == "inputs" {
+			inputs = append(inputs, values...)
+			continue
+		}
+		if flag.Lookup(name) == nil || name == "c" {
+			return nil, fmt.Errorf("%s:%d: unknown setting %s", file, start, name)
+		}
+		if set[name] {
+			continue
+		}
+		for _, v := range values {
+			if err := flag.Set(name, v); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %s", file, start, name, err)
+			}
+		}
+	}
+	if pending != "" {
+		return nil, fmt.Errorf("%s:%d: unterminated array", file, start)
+	}
+
+	return inputs, nil
+}
+
+// Start CPU profiling if requested.  The returned function stops it and
+// writes the heap profile, if one was requested.
+func startProfiling(cpuFile, memFile string) func() {
+	if cpuFile != "" {
+		f, err := os.Create(cpuFile)
+		if err != nil {
+			fmt.Printf("Error creating CPU profile: %v\n", err)
+			os.Exit(1)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Printf("Error starting CPU profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	return func() {
+		if cpuFile != "" {
+			pprof.StopCPUProfile()
+		}
+		if memFile != "" {
+			f, err := os.Create(memFile)
+			if err != nil {
+				fmt.Printf("Error creating memory profile: %v\n", err)
+				os.Exit(1)
+			}
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				fmt.Printf("Error writing memory profile: %v\n", err)
+			}
+			f.Close()
+		}
+	}
+}
+
+// Exit with the given status, stopping profiling first so that the
+// profiles are written.
+func exit(code int) {
+	stopProfiling()
+	os.Exit(code)
 }
 
 func main() {
 
 	outputFile := flag.String("o", "", "The output file (optional)")
 	revDomain := flag.String("d", "", "Reverse Domain (optional)")
+	zoneName := flag.String("z", "", "Origin of forward zones that have no $ORIGIN (optional)")
+	v6Bits := flag.Int("v6-boundary", 48, "Prefix length of generated ip6.arpa zones (multiple of 4)")
+	v4Bits := flag.Int("v4-boundary", 24, "Prefix length of generated in-addr.arpa zones (8, 16 or 24)")
+	flag.Var(&cidrs, "cidr", "Reverse zone for a /8, /16 or /24 network, or RFC 2317 delegation, e.g. 192.0.2.64/26 (repeatable)")
+	split := flag.Bool("split", false, "Write each reverse zone to its own file, named after its origin")
+	outDir := flag.String("outdir", "", "Directory for -split output files (implies -split)")
+	force := flag.Bool("f", false, "Overwrite the output file even if it has a BIND journal")
+	keep := flag.Bool("k", false, "Keep going after parse errors and report them all")
+	units := flag.Bool("ttl-units", false, "Write SOA timers with unit suffixes (1h, 1d, 1w)")
+	includeDepth := flag.Int("include-depth", 16, "Maximum $INCLUDE nesting depth")
+	serial := flag.String("serial", "keep", "SOA serial: keep the forward zone's, or advance the output file's by increment, date (YYYYMMDDnn) or unixtime")
+	flag.Var(&excludeNets, "exclude-net", "Network to generate no PTRs for, e.g. 192.0.2.128/25 (repeatable)")
+	flag.Var(&excludeHosts, "exclude-host", "Hosts to generate no PTRs for: a glob, or /regexp/ (repeatable)")
+	flag.Var(&onlyNets, "only-zone", "Only generate PTRs for this network, e.g. 10.0.0.0/16 (repeatable)")
+	flag.Var(&onlyOrigins, "only-origin", "Only generate reverse zones matching this glob or /regexp/ (repeatable)")
+	ptrTTL := flag.String("ptr-ttl", "", "Give every PTR this TTL, instead of its A record's (optional)")
+	dupPolicy := flag.String("dup-ptr", "all", "Addresses with several hosts: keep first, last, alphabetical, all, or error")
+	adoptFile := flag.String("adopt", "", "Existing reverse zone to report derivable and manual PTRs for (optional)")
+	var dryRun bool
+	flag.BoolVar(&dryRun, "n", false, "Print the PTR changes to the output instead of writing it; exit 1 if there are any")
+	flag.BoolVar(&dryRun, "diff", false, "Same as -n")
+	flag.StringVar(&family, "family", "both", "Address family to generate PTRs for: 4, 6 or both")
+	flag.StringVar(&dualStack, "dual-stack", "off", "Check that hosts have both A and AAAA records: off, warn or require")
+	flag.BoolVar(&fullyQualified, "fqdn", false, "Write fully-qualified owner names and no $ORIGIN lines")
+	serverConf := flag.String("server-conf", "", "Also write zone declarations for the output: bind, knot or nsd (optional)")
+	serverConfFile := flag.String("server-conf-file", "", "File for the -server-conf declarations")
+	flag.BoolVar(&backup, "backup", false, "Keep each overwritten output file as <file>.<timestamp>.bak")
+	config := flag.String("c", "", "Configuration file of flag settings and inputs (optional)")
 	help := flag.Bool("h", false, "Show help")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile to this file (debugging)")
+	memProfile := flag.String("memprofile", "", "Write a memory profile to this file (debugging)")
 
 	flag.Parse()
 	args := flag.Args()
 
+	if *config != "" {
+		inputs, err := loadConfig(*config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			exit(1)
+		}
+		if len(args) == 0 {
+			args = inputs
+		}
+	}
+
 	if len(args) < 1 || *help {
-		fmt.Println("Usage: mkarpa [-o <output file>] [-d <reverse_domain>] <input file> [<input file> ... ]")
-		fmt.Println("Generate a reverse zone file from one or more forward zone files")
+		fmt.Println("Usage: mkarpa [-c <config file>] [-o <output file> | -split [-outdir <dir>]] [-n] [-backup] [-server-conf bind|knot|nsd -server-conf-file <file>] [-z <zone>] [-fqdn] [-family 4|6|both] [-dual-stack off|warn|require] [-v4-boundary N] [-v6-boundary N] [-cidr <network>] [-f] [-k] [-ttl-units] [-ptr-ttl <ttl>] [-serial keep|increment|date|unixtime] [-dup-ptr first|last|alphabetical|error|all] [-exclude-net <network>] [-exclude-host <pattern>] [-only-zone <network>] [-only-origin <pattern>] [-d <reverse_domain>] [-adopt <reverse zone>] <input file> [<input file> ... ]")
+		fmt.Println("Generate a reverse zone file from one or more forward zone files ('-' reads stdin)")
 		flag.PrintDefaults()
-		os.Exit(1)
+		exit(1)
 	}
 
+	stopProfiling = startProfiling(*cpuProfile, *memProfile)
+	defer stopProfiling()
+
 	domain = *revDomain
+	if domain != "" && !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+	directiveOrigin = domain
+	keepGoing = *keep
+
+	if *v6Bits < 4 || *v6Bits > 124 || *v6Bits%4 != 0 {
+		fmt.Println("Error: -v6-boundary must be a multiple of 4 between 4 and 124")
+		exit(1)
+	}
+	v6Boundary = *v6Bits
+
+	if *outputFile != "" && (*split || *outDir != "") {
+		fmt.Println("Error: -o can't be used with -split or -outdir")
+		exit(1)
+	}
+
+	if *v4Bits != 8 && *v4Bits != 16 && *v4Bits != 24 {
+		fmt.Println("Error: -v4-boundary must be 8, 16 or 24")
+		exit(1)
+	}
+	v4Boundary = *v4Bits
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "v4-boundary" {
+			v4BoundarySet = true
+		}
+	})
+
+	if family != "4" && family != "6" && family != "both" {
+		fmt.Printf("Error: unknown -family '%s'\n", family)
+		exit(1)
+	}
+	switch dualStack {
+	case "off":
+	case "warn", "require":
+		families = make(map[string]int)
+	default:
+		fmt.Printf("Error: unknown -dual-stack mode '%s'\n", dualStack)
+		exit(1)
+	}
+
+	switch *serverConf {
+	case "", "bind", "knot", "nsd":
+	default:
+		fmt.Printf("Error: unknown -server-conf format '%s'\n", *serverConf)
+		exit(1)
+	}
+	if *serverConf != "" && (*serverConfFile == "" || (*outputFile == "" && !*split && *outDir == "")) {
+		fmt.Println("Error: -server-conf needs -server-conf-file, and -o, -split or -outdir")
+		exit(1)
+	}
+
+	switch *dupPolicy {
+	case "first", "last", "alphabetical", "error", "all":
+	default:
+		fmt.Printf("Error: unknown -dup-ptr policy '%s'\n", *dupPolicy)
+		exit(1)
+	}
+
+	switch *serial {
+	case "keep", "increment", "date", "unixtime":
+		serialMode = *serial
+	default:
+		fmt.Printf("Error: unknown -serial mode '%s'\n", *serial)
+		exit(1)
+	}
+	maxIncludeDepth = *includeDepth
+	ttlUnits = *units
 
 	// Process all the inputs
 	zone = list.New()
+	// Like named-checkzone, the zone name supplies the initial $ORIGIN
+	origin := *zoneName
+	if origin != "" && !strings.HasSuffix(origin, ".") {
+		origin += "."
+	}
 	for _, inputFile := range args {
-		parseZone(inputFile)
+		parseZone(inputFile, origin)
+	}
+
+	if len(parseErrors) > 0 {
+		for _, err := range parseErrors {
+			fmt.Fprintf(os.Stderr, "Parse Error: %s\n", err)
+		}
+		fmt.Fprintf(os.Stderr, "%d parse errors\n", len(parseErrors))
+		exit(1)
+	}
+
+	for _, r := range dedupe() {
+		if p, ok := r.(*ptr_t); ok {
+			fmt.Fprintf(os.Stderr, "Warning: removed duplicate PTR %s -> %s\n", reverseName(p.addr), p.host)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: removed duplicate %s\n", r)
+		}
+	}
+
+	if families != nil {
+		missing := singleStack()
+		for _, m := range missing {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", m)
+		}
+		if dualStack == "require" && len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: %d hosts are not dual-stack\n", len(missing))
+			exit(1)
+		}
+	}
+
+	// PTRs carry their A record's TTL, unless it is the zone's default
+	if *ptrTTL != "" {
+		v, err := parseTTL(*ptrTTL)
+		if err != nil {
+			fmt.Printf("Error: invalid -ptr-ttl: %v\n", err)
+			exit(1)
+		}
+		ttl = fmt.Sprintf("$TTL %d", v)
+		uniformTTL = true
+	}
+	if f := strings.Fields(ttl); len(f) > 1 {
+		if v, err := parseTTL(f[1]); err == nil {
+			outputTTL = strconv.FormatUint(v, 10)
+		}
+	}
+
+	collisions := resolveCollisions(*dupPolicy)
+	for _, c := range collisions {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", c)
+	}
+	if *dupPolicy == "error" && len(collisions) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d addresses have conflicting PTRs\n", len(collisions))
+		exit(1)
+	}
+
+	// Compare with the existing output instead of writing it
+	if dryRun {
+		var existing []string
+		if *split || *outDir != "" {
+			origins, _ := splitZones()
+			for _, o := range origins {
+				existing = append(existing, filepath.Join(*outDir, zoneFileName(o)))
+			}
+		} else if *outputFile != "" {
+			existing = append(existing, *outputFile)
+		} else {
+			fmt.Println("Error: -diff needs -o, -split or -outdir")
+			exit(1)
+		}
+		if diff(os.Stdout, existing) > 0 {
+			exit(1)
+		}
+		return
+	}
+
+	// The output's SOA comes from the input
+	if soa.authns == "" {
+		fmt.Fprintf(os.Stderr, "Error: no SOA record in the input zones\n")
+		exit(1)
+	}
+
+	// The zones and files for -server-conf.  A single output file can only
+	// be declared as one zone.
+	var confOrigins, confFiles []string
+	if *serverConf != "" {
+		confOrigins, _ = splitZones()
+		for _, o := range confOrigins {
+			if *split || *outDir != "" {
+				confFiles = append(confFiles, filepath.Join(*outDir, zoneFileName(o)))
+			} else {
+				confFiles = append(confFiles, *outputFile)
+			}
+		}
+		if len(confOrigins) > 1 && !*split && *outDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: %s would hold %d reverse zones; use -split for -server-conf\n", *outputFile, len(confOrigins))
+			exit(1)
+		}
+	}
+
+	// Report on the existing zone before the output can overwrite it
+	if *adoptFile != "" {
+		adopt(os.Stderr, *adoptFile)
 	}
 
 	// Generate output
-	var outFile *os.File = os.Stdout
-	var err error
-	if *outputFile != "" {
-		// Output to the specified file
-		outFile, err = os.Create(*outputFile)
+	if *split || *outDir != "" {
+		mkarpaSplit(*outDir, args, *force)
+	} else {
+		soa.serial = outputSerial(soa.serial, *outputFile)
+
+		var buf bytes.Buffer
+		mkarpa(&buf, args)
+
+		name := *outputFile
+		if name == "" {
+			name = "<stdout>"
+		}
+		want, err := generatedPTRs()
+		if err == nil {
+			err = validateZone(name, buf.Bytes(), want)
+		}
 		if err != nil {
-			fmt.Printf("Error creating output file: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Error: generated zone failed validation, not written: %v\n", err)
+			exit(1)
 		}
-		defer outFile.Close()
+		writeOutput(*outputFile, *force, buf.Bytes())
 	}
 
-	mkarpa(outFile, args)
+	if *serverConf != "" {
+		var buf bytes.Buffer
+		writeServerConf(&buf, *serverConf, confOrigins, confFiles)
+		writeOutput(*serverConfFile, true, buf.Bytes())
+	}
 }
@@ -0,0 +1,307 @@
+// The tools are separate main packages sharing one directory, so go test
+// needs the tool's source file and the golden-file harness named alongside
+// its tests:
+//
+//	go test mkarpa.go mkarpa_test.go golden_test.go
+
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSerialAdd(t *testing.T) {
+	tests := []struct {
+		s, n    uint32
+		want    uint32
+		wantErr bool
+	}{
+		{1, 1, 2, false},
+		{math.MaxUint32, 1, 0, false},
+		{5, math.MaxInt32, 5 + math.MaxInt32, false},
+		{1, 1 << 31, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := SerialAdd(tt.s, tt.n)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("SerialAdd(%d, %d) = %d, %v; want %d, error %v", tt.s, tt.n, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSerialCompare(t *testing.T) {
+	tests := []struct {
+		s1, s2  uint32
+		want    int
+		wantErr bool
+	}{
+		{1, 1, 0, false},
+		{2, 1, 1, false},
+		{1, 2, -1, false},
+		{0, math.MaxUint32, 1, false},
+		{math.MaxUint32, 0, -1, false},
+		{0, 1 << 31, 0, true},
+		{1 << 31, 0, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := SerialCompare(tt.s1, tt.s2)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("SerialCompare(%d, %d) = %d, %v; want %d, error %v", tt.s1, tt.s2, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    uint64
+		wantErr bool
+	}{
+		{"3600", 3600, false},
+		{"1h30m", 5400, false},
+		{"1W", 604800, false},
+		{"1d2h", 93600, false},
+		{"1h30", 3630, false},
+		{"", 0, true},
+		{"h", 0, true},
+		{"1x", 0, true},
+		{"4294967296", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTTL(tt.v)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTTL(%q) = %d, %v; want %d, error %v", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatTTL(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want string
+	}{
+		{0, "0"},
+		{61, "1m1s"},
+		{5400, "1h30m"},
+		{93600, "1d2h"},
+		{604800, "1w"},
+	}
+	for _, tt := range tests {
+		if got := formatTTL(tt.v); got != tt.want {
+			t.Errorf("formatTTL(%d) = %q; want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestNibbles(t *testing.T) {
+	tests := []struct {
+		v, width int
+		upper    bool
+		want     string
+	}{
+		{0, 0, false, "0"},
+		{0x1a, 0, false, "a.1"},
+		{0x1a, 0, true, "A.1"},
+		{1, 7, false, "1.0.0.0"},
+		{0x12345, 3, false, "5.4.3.2.1"},
+	}
+	for _, tt := range tests {
+		if got := nibbles(tt.v, tt.width, tt.upper); got != tt.want {
+			t.Errorf("nibbles(%#x, %d, %v) = %q; want %q", tt.v, tt.width, tt.upper, got, tt.want)
+		}
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl    string
+		i       int
+		want    string
+		wantErr bool
+	}{
+		{"host-$", 5, "host-5", false},
+		{`\$$`, 5, "$5", false},
+		{"${10,3,d}", 5, "015", false},
+		{"${0,2,x}", 255, "ff", false},
+		{"${0,4,X}", 255, "00FF", false},
+		{"${1,0,n}", 0x1f, "0.2", false},
+		{"${-1,0,n}", 0, "", true},
+		{"${0,0,q}", 1, "", true},
+		{"${0", 1, "", true},
+	}
+	for _, tt := range tests {
+		got, err := expandTemplate(tt.tmpl, tt.i)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("expandTemplate(%q, %d) = %q, %v; want %q, error %v", tt.tmpl, tt.i, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestConvertGenerate(t *testing.T) {
+	v4Boundary, v6Boundary = 24, 48
+
+	tests := []struct {
+		directive string
+		want      []string // Range, owner and origin of each converted directive
+		wantErr   bool
+	}{
+		{"$GENERATE 1-10 host-$ A 192.0.2.$",
+			[]string{"1-10/1 $.2.0.192.in-addr.arpa. 2.0.192.in-addr.arpa."}, false},
+		{"$GENERATE 10-20/5 host-$ A 192.0.2.$",
+			[]string{"10-20/5 $.2.0.192.in-addr.arpa. 2.0.192.in-addr.arpa."}, false},
+		{"$GENERATE 0-3/2 host-$ A 10.0.$.1",
+			[]string{
+				"0-0/2 1.0.0.10.in-addr.arpa. 0.0.10.in-addr.arpa.",
+				"2-2/2 1.2.0.10.in-addr.arpa. 2.0.10.in-addr.arpa.",
+			}, false},
+		{"$GENERATE 0-15 host-$ AAAA 2001:db8::${0,4,x}",
+			[]string{"0-15/1 ${0,7,n}.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."}, false},
+		{"$GENERATE 1-10/0 host-$ A 192.0.2.$", nil, true},
+		{"$GENERATE 10-1 host-$ A 192.0.2.$", nil, true},
+		{"$GENERATE 0-300 host-$ A 192.0.2.$", nil, true},
+		{"$GENERATE 1-10 host-$ CNAME www", nil, true},
+	}
+	for _, tt := range tests {
+		gens, err := ConvertGenerate(tt.directive, "example.com.")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ConvertGenerate(%q) error = %v; want error %v", tt.directive, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, g := range gens {
+			if g.host != "host-$.example.com." {
+				t.Errorf("ConvertGenerate(%q) host = %q; want %q", tt.directive, g.host, "host-$.example.com.")
+			}
+			got = append(got, fmt.Sprintf("%d-%d/%d %s %s", g.start, g.stop, g.step, g.owner, g.origin))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("ConvertGenerate(%q) = %q; want %q", tt.directive, got, tt.want)
+		}
+	}
+}
+
+func TestReverseGenerate(t *testing.T) {
+	tests := []struct {
+		directive string
+		want      int // Number of PTRs
+		wantErr   bool
+	}{
+		{"$GENERATE 1-3 $ PTR h$.example.com.", 3, false},
+		{"$GENERATE 1-10/3 $ PTR h$.example.com.", 4, false},
+		{"$GENERATE 1-10/0 $ PTR h$.example.com.", 0, true},
+		{"$GENERATE 10-1 $ PTR h$.example.com.", 0, true},
+		{"$GENERATE 0-3 ${-1,0,n} PTR h$.example.com.", 0, true},
+	}
+	for _, tt := range tests {
+		ptrs, err := reverseGenerate(strings.Fields(tt.directive), "2.0.192.in-addr.arpa.")
+		if (err != nil) != tt.wantErr || len(ptrs) != tt.want {
+			t.Errorf("reverseGenerate(%q) = %d PTRs, %v; want %d, error %v", tt.directive, len(ptrs), err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	tests := []struct {
+		raw  string
+		n    int
+		want int
+	}{
+		{"h1q 1q IN A 192.0.2.1\n", 1, 5},
+		{"192 IN A 192.0.2.x ; 192\n", 3, 10},
+		{"\tIN 3q A 192.0.2.3\n", 1, 5},
+		{"w IN A (192.0.2.4\n", 3, 9},
+		{"w IN A ( ; 192.0.2.4\n", 3, 0},
+		{"$TTL 1q\n", 1, 6},
+	}
+	for _, tt := range tests {
+		if got := column(tt.raw, tt.n); got != tt.want {
+			t.Errorf("column(%q, %d) = %d; want %d", tt.raw, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestGolden(t *testing.T) {
+	runGolden(t, "mkarpa", []golden_t{
+		{"basic", []string{"basic.zone"}, false},
+		{"basic-fqdn", []string{"-fqdn", "-ttl-units", "basic.zone"}, false},
+		{"signed", []string{"signed.zone"}, false},
+		{"generate", []string{"generate.zone"}, false},
+		{"pathological", []string{"pathological.zone"}, false},
+		{"pathological-dup-first", []string{"-dup-ptr", "first", "pathological.zone"}, false},
+		{"errors", []string{"-k", "errors.zone"}, true},
+		{"large", []string{"large.zone"}, false},
+	}, nil)
+}
+
+// A forward zone of n hosts, one A record each, numbered out of 10.0.0.0/8
+// as zonesynth does.
+func benchZone(n int) string {
+	var b strings.Builder
+	b.WriteString("$TTL 3600\n$ORIGIN bench.example.\n")
+	b.WriteString("@ IN SOA ns1 hostmaster ( 1 3600 900 604800 3600 )\n  IN NS ns1\nns1 IN A 192.0.2.1 ;inaddr\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "host-%07d\tIN\tA\t10.%d.%d.%d\n", i+1, i/254/256%256, i/254%256, i%254+1)
+	}
+	return b.String()
+}
+
+// Parse a zone into the global state, as main does.
+func benchParse(data string) {
+	zone = list.New()
+	soa = soa_t{}
+	parseOneZone("bench.zone", "", bufio.NewReader(strings.NewReader(data)))
+}
+
+func BenchmarkParse(b *testing.B) {
+	v4Boundary, v6Boundary = 24, 48
+	data := benchZone(100000)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchParse(data)
+	}
+}
+
+func BenchmarkOutput(b *testing.B) {
+	v4Boundary, v6Boundary = 24, 48
+	benchParse(benchZone(100000))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mkarpa(io.Discard, nil)
+	}
+}
+
+func BenchmarkConvertGenerate(b *testing.B) {
+	v4Boundary, v6Boundary = 24, 48
+	for _, c := range []struct{ name, directive string }{
+		{"one-zone", "$GENERATE 1-254 host-$ A 192.0.2.$"},
+		{"split", "$GENERATE 0-255 host-$ A 10.0.$.1"},
+		{"ipv6", "$GENERATE 0-65535 host-$ AAAA 2001:db8::${0,4,x}"},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ConvertGenerate(c.directive, "example.com."); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExpandTemplate(b *testing.B) {
+	for _, tmpl := range []string{"host-$", "dhcp-${10,3,d}", "${0,7,n}.ip6"} {
+		b.Run(tmpl, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := expandTemplate(tmpl, i%65536); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
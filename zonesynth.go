@@ -0,0 +1,142 @@
+package main
+
+// Generate large synthetic forward zones for load testing
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Hosts are numbered out of 10.0.0.0/8 and $GENERATE ranges out of
+// 172.16.0.0/12, one /24 per range.
+const maxHosts = 254 * 256 * 256
+const maxRanges = 16 * 256
+
+func ipString(base, n uint32) string {
+	ip := base + n
+	return fmt.Sprintf("%d.%d.%d.%d", byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
+}
+
+// Map a host index onto 10.0.0.0/8, skipping the .0 and .255 addresses
+// of each /24.
+func hostAddress(i int) (string, string) {
+	block := uint32(i/254) << 8
+	host := uint32(i%254) + 1
+	ip := ipString(10<<24, block+host)
+	rev := fmt.Sprintf("%d.%d.10.in-addr.arpa.", byte(block>>8), byte(block>>16))
+	return ip, rev
+}
+
+func writeHeader(w io.Writer, origin string, serial string) {
+	fmt.Fprintf(w, "; Synthetic zone generated %s\n", time.Now().Format(time.UnixDate))
+	fmt.Fprintf(w, "$TTL 3600\n")
+	fmt.Fprintf(w, "$ORIGIN %s\n", origin)
+	fmt.Fprintf(w, "@\tIN\tSOA\tns1.%s hostmaster.%s (\n", origin, origin)
+	fmt.Fprintf(w, "\t\t%s\t; Serial\n", serial)
+	fmt.Fprintf(w, "\t\t3600\t\t; Refresh\n")
+	fmt.Fprintf(w, "\t\t900\t\t; Retry\n")
+	fmt.Fprintf(w, "\t\t604800\t\t; Expire\n")
+	fmt.Fprintf(w, "\t\t3600 )\t\t; Minimum\n")
+	fmt.Fprintf(w, "\tIN\tNS\tns1.%s\n", origin)
+	fmt.Fprintf(w, "\tIN\tNS\tns2.%s\n", origin)
+	fmt.Fprintf(w, "\tIN\tMX\t10 mail.%s\n", origin)
+	fmt.Fprintf(w, "ns1\tIN\tA\t192.0.2.1 ;inaddr\n")
+	fmt.Fprintf(w, "ns2\tIN\tA\t192.0.2.2 ;inaddr\n")
+	fmt.Fprintf(w, "mail\tIN\tA\t192.0.2.3 ;inaddr\n")
+}
+
+func writeHosts(w io.Writer, hosts int, txtRatio float64, rnd *rand.Rand) {
+	var lastRev string
+
+	for i := 0; i < hosts; i++ {
+		ip, rev := hostAddress(i)
+		if rev != lastRev {
+			fmt.Fprintf(w, ";$reverse-domain %s\n", rev)
+			lastRev = rev
+		}
+
+		host := fmt.Sprintf("host-%07d", i+1)
+		fmt.Fprintf(w, "%s\tIN\tA\t%s\n", host, ip)
+		if rnd.Float64() < txtRatio {
+			fmt.Fprintf(w, "\t\tIN\tTXT\t\"asset-tag=%08x\"\n", rnd.Uint32())
+		}
+	}
+}
+
+func writeRanges(w io.Writer, ranges int, origin string) {
+	for i := 0; i < ranges; i++ {
+		b2 := 16 + i/256
+		b3 := i % 256
+		fmt.Fprintf(w, ";$reverse-domain %d.%d.172.in-addr.arpa.\n", b3, b2)
+		fmt.Fprintf(w, "$GENERATE 1-254 dhcp-%d-%d-${0,3,d}.%s IN A 172.%d.%d.$\n", b2, b3, origin, b2, b3)
+	}
+}
+
+func main() {
+	hosts := flag.Int("hosts", 1000, "Number of A records to generate")
+	txtRatio := flag.Float64("txt-ratio", 0, "Fraction of hosts that also get a TXT record (0.0 - 1.0)")
+	ranges := flag.Int("generate-ranges", 0, "Number of /24 $GENERATE ranges to add")
+	origin := flag.String("origin", "synth.example.", "DNS domain (optional)")
+	seed := flag.Int64("seed", 1, "Random seed for TXT record selection (optional)")
+	outputFile := flag.String("o", "", "Output file (optional)")
+	help := flag.Bool("h", false, "Show help")
+
+	flag.Parse()
+
+	if len(flag.Args()) != 0 || *help {
+		fmt.Println("Usage: zonesynth [-hosts N] [-txt-ratio R] [-generate-ranges N] [-origin origin] [-seed N] [-o output]")
+		fmt.Println("Create a synthetic forward zone for load testing")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *hosts < 0 || *hosts > maxHosts {
+		fmt.Printf("Error: -hosts must be between 0 and %d.\n", maxHosts)
+		os.Exit(1)
+	}
+
+	if *ranges < 0 || *ranges > maxRanges {
+		fmt.Printf("Error: -generate-ranges must be between 0 and %d.\n", maxRanges)
+		os.Exit(1)
+	}
+
+	if *txtRatio < 0 || *txtRatio > 1 {
+		fmt.Println("Error: -txt-ratio must be between 0.0 and 1.0.")
+		os.Exit(1)
+	}
+
+	o := *origin
+	if o == "" || o[len(o)-1] != '.' {
+		o += "."
+	}
+
+	// Generate output
+	var outFile *os.File = os.Stdout
+	var err error
+	if *outputFile != "" {
+		// Output to the specified file
+		outFile, err = os.Create(*outputFile)
+		if err != nil {
+			fmt.Printf("Error creating output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer outFile.Close()
+	}
+
+	w := bufio.NewWriter(outFile)
+	rnd := rand.New(rand.NewSource(*seed))
+
+	writeHeader(w, o, time.Now().Format("2006010200"))
+	writeHosts(w, *hosts, *txtRatio, rnd)
+	writeRanges(w, *ranges, o)
+
+	if err := w.Flush(); err != nil {
+		fmt.Printf("Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+}
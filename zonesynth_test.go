@@ -0,0 +1,23 @@
+// Run with the tool's source and the golden-file harness; see README.md:
+//
+//	go test zonesynth.go zonesynth_test.go golden_test.go
+
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+// The SOA serial is today's date
+var serialLine = regexp.MustCompile(`(?m)^\t\t\d{10}\t; Serial$`)
+
+func TestGolden(t *testing.T) {
+	runGolden(t, "zonesynth", []golden_t{
+		{"small", []string{"-hosts", "20"}, false},
+		{"mixed", []string{"-hosts", "300", "-txt-ratio", "0.2", "-generate-ranges", "3", "-origin", "test.example", "-seed", "7"}, false},
+		{"bad-hosts", []string{"-hosts", "-1"}, true},
+	}, func(s string) string {
+		return serialLine.ReplaceAllString(s, "\t\t<serial>\t; Serial")
+	})
+}